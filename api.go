@@ -0,0 +1,459 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+const API_PREFIX = "/api/v1/"
+
+type ScheduleTask struct {
+	Name string `json:"name"`
+	Spec string `json:"spec"`
+}
+
+// scheduleTasks are the default cron tasks started with every server, the
+// [schedule] section of config.ini overrides their spec.
+var scheduleTasks = []ScheduleTask{
+	{"hourTask", "0 0 * * * ?"},
+	{"tenMinTask", "0 5/10 * * * ?"},
+	{"reconcileTask", "30 * * * * ?"},
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiStatus struct {
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	Port         int       `json:"port"`
+	Mode         string    `json:"mode"`
+	ServerStart  bool      `json:"serverStart"`
+	ServerRun    bool      `json:"serverRun"`
+	Players      int       `json:"players"`
+	CpuTemp      float64   `json:"cpuTemp"`
+	CurrProcCmd  string    `json:"currProcCmd"`
+	Game         GameState `json:"game"`
+	StartTime    time.Time `json:"startTime"`
+	JvmStartTime time.Time `json:"jvmStartTime"`
+	Uptime       int64     `json:"uptime"`
+	Restarts     int       `json:"restarts"`
+}
+
+type apiPlayer struct {
+	Name     string    `json:"name"`
+	Uuid     string    `json:"uuid"`
+	Role     string    `json:"role"`
+	JoinTime time.Time `json:"joinTime"`
+}
+
+type apiAdmin struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type apiVote struct {
+	InProgress bool           `json:"inProgress"`
+	Cmd        string         `json:"cmd"`
+	Votes      map[string]int `json:"votes"`
+	Players    int            `json:"players"`
+}
+
+type apiSchedule struct {
+	ScheduleTask
+	Next time.Time `json:"next"`
+}
+
+type apiMaps struct {
+	Server []string   `json:"server"`
+	Files  []FileDesc `json:"files"`
+}
+
+// apiAction is the body of POST /api/v1/actions/<name>, which fields are
+// used depends on the action.
+type apiAction struct {
+	Message string `json:"message"`
+	Map     string `json:"map"`
+	Mode    string `json:"mode"`
+	Slot    string `json:"slot"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+}
+
+// apiHandler serves /api/v1/. It is reached through WebAuth.protect, so the
+// caller is either a logged in admin or the owner of an api token.
+type apiHandler struct {
+	mindustry *Mindustry
+}
+
+func userRole(user User) string {
+	if user.isSuperAdmin {
+		return "superAdmin"
+	} else if user.isAdmin {
+		return "admin"
+	}
+	return "user"
+}
+
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (this *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, API_PREFIX), "/")
+	if strings.HasPrefix(path, "actions/") {
+		if r.Method != "POST" {
+			writeJson(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+			return
+		}
+		this.handleAction(w, r, webUser(r), strings.TrimPrefix(path, "actions/"))
+		return
+	}
+	if path == "config/reload" {
+		if r.Method != "POST" {
+			writeJson(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+			return
+		}
+		this.handleReload(w, webUser(r))
+		return
+	}
+	if r.Method != "GET" {
+		writeJson(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		return
+	}
+	m := this.mindustry
+	switch path {
+	case "maps":
+		m.lock.Lock()
+		maps := apiMaps{Server: append([]string{}, m.maps...), Files: []FileDesc{}}
+		m.lock.Unlock()
+		files, _ := ioutil.ReadDir(FILE_PATH)
+		for _, f := range files {
+			if !f.IsDir() && checkExt(f.Name()) {
+				maps.Files = append(maps.Files, FileDesc{Id: len(maps.Files) + 1, Name: f.Name(), Size: f.Size()})
+			}
+		}
+		writeJson(w, http.StatusOK, maps)
+	case "saves":
+		saves, err := getSaveList(m.savePath())
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, saves)
+	case "bans":
+		bans, err := m.queryBans()
+		if err != nil {
+			writeJson(w, http.StatusConflict, apiError{err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, bans)
+	case "servers":
+		writeJson(w, http.StatusOK, m.servers.infos())
+	case "chatlog":
+		query, err := parseChatQuery(r)
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		entries, err := m.servers.chatLog.search(query)
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, entries)
+	case "audit":
+		query, err := parseAuditQuery(r)
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		entries, err := m.servers.auditLog.last(query)
+		if err != nil {
+			writeJson(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJson(w, http.StatusOK, entries)
+	case "schedule":
+		schedules := []apiSchedule{}
+		now := time.Now()
+		m.lock.Lock()
+		tasks := m.schedule
+		m.lock.Unlock()
+		for _, task := range tasks {
+			schedule := apiSchedule{ScheduleTask: task}
+			if sched, err := cron.Parse(task.Spec); err == nil {
+				schedule.Next = sched.Next(now)
+			}
+			schedules = append(schedules, schedule)
+		}
+		writeJson(w, http.StatusOK, schedules)
+	default:
+		m.lock.Lock()
+		v, ok := this.state(path)
+		m.lock.Unlock()
+		if !ok {
+			writeJson(w, http.StatusNotFound, apiError{"not found"})
+			return
+		}
+		if status, ok := v.(apiStatus); ok {
+			status.CpuTemp = getCpuTemp()
+			v = status
+		}
+		writeJson(w, http.StatusOK, v)
+	}
+}
+
+// parseChatQuery reads the filters of chatlog: player, server, type, text,
+// from and to(RFC3339 or 2006-01-02) and limit.
+func parseChatQuery(r *http.Request) (ChatQuery, error) {
+	values := r.URL.Query()
+	query := ChatQuery{Name: values.Get("player"), Server: values.Get("server"), Type: values.Get("type"), Text: values.Get("text")}
+	for _, param := range []string{"from", "to"} {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+				return query, fmt.Errorf("%s %q is not a time", param, value)
+			}
+			if param == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		if param == "from" {
+			query.From = t
+		} else {
+			query.To = t
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 1000 {
+			return query, fmt.Errorf("limit %q is not 1-1000", limit)
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+// parseAuditQuery reads the filters of GET audit: actor, source, command,
+// server and limit.
+func parseAuditQuery(r *http.Request) (AuditQuery, error) {
+	values := r.URL.Query()
+	query := AuditQuery{Actor: values.Get("actor"), Source: values.Get("source"), Command: values.Get("command"), Server: values.Get("server")}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > 1000 {
+			return query, fmt.Errorf("limit %q is not 1-1000", limit)
+		}
+		query.Limit = n
+	}
+	return query, nil
+}
+
+// state builds the endpoints that only read the Mindustry state, it runs with
+// the Mindustry lock held.
+func (this *apiHandler) state(path string) (interface{}, bool) {
+	m := this.mindustry
+	switch path {
+	case "status":
+		var uptime int64
+		if m.serverIsRun {
+			uptime = int64(time.Since(m.jvmStartTime) / time.Second)
+		}
+		return apiStatus{
+			Name:         m.name,
+			Version:      _VERSION_,
+			Port:         m.port,
+			Mode:         m.mode,
+			ServerStart:  m.serverIsStart,
+			ServerRun:    m.serverIsRun,
+			Players:      m.onlineCount(),
+			CurrProcCmd:  m.currProcCmd,
+			Game:         m.game,
+			StartTime:    m.startTime,
+			JvmStartTime: m.jvmStartTime,
+			Uptime:       uptime,
+			Restarts:     m.restarts,
+		}, true
+	case "players":
+		players := []apiPlayer{}
+		for _, player := range m.online {
+			players = append(players, apiPlayer{player.Name, player.Uuid, userRole(m.users[player.Name]), player.JoinTime})
+		}
+		sort.Slice(players, func(i, j int) bool {
+			return players[i].JoinTime.Before(players[j].JoinTime)
+		})
+		return players, true
+	case "chat":
+		return append([]ChatMsg{}, m.chats...), true
+	case "jobs":
+		jobs := []Job{}
+		for i := len(m.jobs) - 1; i >= 0; i-- {
+			jobs = append(jobs, *m.jobs[i])
+		}
+		return jobs, true
+	case "admins":
+		admins := []apiAdmin{}
+		for name, user := range m.users {
+			if user.isAdmin && name != "Server" {
+				admins = append(admins, apiAdmin{name, userRole(user)})
+			}
+		}
+		sort.Slice(admins, func(i, j int) bool {
+			return admins[i].Name < admins[j].Name
+		})
+		return admins, true
+	case "vote":
+		vote := apiVote{InProgress: len(m.votetickUsers) > 0, Cmd: m.votetickCmd, Votes: make(map[string]int), Players: m.onlineCount()}
+		for name, isAgree := range m.votetickUsers {
+			vote.Votes[name] = isAgree
+		}
+		return vote, true
+	}
+	return nil, false
+}
+
+// handleReload works like \reload but also when the server is not running,
+// the response lists what changed.
+func (this *apiHandler) handleReload(w http.ResponseWriter, userName string) {
+	m := this.mindustry
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cmd, ok := m.cmds["reload"]
+	if !ok {
+		m.audit(AUDIT_HTTP, userName, "reload", cmdResult(errCmdInvalid))
+		writeJson(w, http.StatusNotFound, apiError{errCmdInvalid.Error()})
+		return
+	}
+	if m.users[userName].level < cmd.level {
+		m.audit(AUDIT_HTTP, userName, "reload", cmdResult(errCmdPermissionDenied))
+		writeJson(w, http.StatusForbidden, apiError{errCmdPermissionDenied.Error()})
+		return
+	}
+	diff, err := m.reloadConfig()
+	if err != nil {
+		m.audit(AUDIT_HTTP, userName, "reload", "failed")
+		writeJson(w, http.StatusUnprocessableEntity, apiError{err.Error()})
+		return
+	}
+	m.audit(AUDIT_HTTP, userName, "reload", "ok")
+	writeJson(w, http.StatusOK, map[string][]string{"changes": diff})
+}
+
+// handleAction turns an action into the chat command a player would type and
+// runs it through procUsrCmd, so the permission lists in config.ini apply.
+func (this *apiHandler) handleAction(w http.ResponseWriter, r *http.Request, userName string, action string) {
+	var req apiAction
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil && err != io.EOF {
+		writeJson(w, http.StatusBadRequest, apiError{"invalid request"})
+		return
+	}
+	userInput := ""
+	switch action {
+	case "say":
+		userInput = "say " + req.Message
+	case "host":
+		userInput = strings.TrimSpace("host " + req.Map + " " + req.Mode)
+	case "load":
+		userInput = "load " + req.Slot
+	case "save":
+		userInput = strings.TrimSpace("save " + req.Slot)
+	case "kick":
+		userInput = "kick " + req.Name
+	case "admin":
+		userInput = "admin " + req.Name
+	case "ban":
+		banType := req.Type
+		if banType == "" {
+			banType = "name"
+		}
+		userInput = "ban " + banType + " " + req.Name
+	case "gameover", "restart", "cancel":
+		userInput = action
+	default:
+		writeJson(w, http.StatusNotFound, apiError{"unknown action:" + action})
+		return
+	}
+	if strings.ContainsAny(userInput, "\r\n") {
+		writeJson(w, http.StatusBadRequest, apiError{"invalid request"})
+		return
+	}
+	m := this.mindustry
+	m.lock.Lock()
+	var err error = errServerNotRunning
+	if m.in != nil {
+		err = m.procUsrCmd(m.in, userName, userInput, AUDIT_HTTP)
+	} else {
+		m.audit(AUDIT_HTTP, userName, userInput, cmdResult(err))
+	}
+	m.lock.Unlock()
+	switch err {
+	case nil:
+		writeJson(w, http.StatusOK, map[string]string{"result": "ok"})
+	case errCmdInvalid:
+		writeJson(w, http.StatusNotFound, apiError{err.Error()})
+	case errCmdPermissionDenied:
+		writeJson(w, http.StatusForbidden, apiError{err.Error()})
+	case errCmdExecuting:
+		writeJson(w, http.StatusConflict, apiError{err.Error()})
+	case errServerNotRunning:
+		writeJson(w, http.StatusServiceUnavailable, apiError{err.Error()})
+	default:
+		writeJson(w, http.StatusUnprocessableEntity, apiError{err.Error()})
+	}
+}
+
+// bansLineR matches the lines of the answer of bans, eg
+// "Banned players [ID]:" or "AAAAAAAAAAA= / Last known name: 'Anuke'".
+var bansLineR = regexp.MustCompile("^(Banned players|No (ID|IP)-banned players|.+ / Last known name: )")
+
+// bansSpec collects the answer of bans. The server only prints a last line
+// when there are no ip bans, otherwise the answer ends with the timeout.
+var bansSpec = CmdSpec{
+	match: bansLineR.MatchString,
+	done: func(line string) bool {
+		return strings.HasPrefix(line, "No IP-banned players")
+	},
+	timeout: 2 * time.Second,
+}
+
+// queryBans asks the server for its ban list the same way \maps asks for
+// the map list.
+func (this *Mindustry) queryBans() ([]string, error) {
+	this.lock.Lock()
+	if !this.serverIsRun {
+		this.lock.Unlock()
+		return nil, errServerNotRunning
+	}
+	future := this.executor.send("bans", bansSpec)
+	this.lock.Unlock()
+	lines, err := future.wait()
+	if err != nil && err != errCmdTimeout {
+		return nil, err
+	}
+	bans := []string{}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "Banned players") && !strings.HasPrefix(line, "No ID-banned players") && !strings.HasPrefix(line, "No IP-banned players") {
+			bans = append(bans, line)
+		}
+	}
+	return bans, nil
+}
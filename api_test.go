@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testIni = `[server]
+admins=admin
+superAdmins=super
+normCmds=help,maps,status,lang
+adminCmds=help,maps,status,lang,kick,host,load,save,cancel,gameover
+superAdminCmds=help,maps,status,lang,kick,host,load,save,cancel,gameover,say,ban,reload,config,restart
+votetickCmds=gameover
+`
+
+// newTestMindustry runs init with the config ini in an empty directory, the
+// server is running with in as its stdin.
+func newTestMindustry(t *testing.T, ini string, in *recordWriter) *Mindustry {
+	chdirTemp(t)
+	os.MkdirAll(LOCALE_PATH, 0777)
+	ioutil.WriteFile(LOCALE_PATH+LOCALE_FALLBACK+".json", []byte("{}"), 0666)
+	oldFile := configFile
+	t.Cleanup(func() { configFile = oldFile })
+	configFile = filepath.Join(t.TempDir(), "config.ini")
+	if err := ioutil.WriteFile(configFile, []byte(ini), 0666); err != nil {
+		t.Fatal(err)
+	}
+	servers := &Servers{langs: loadPlayerLangs(PLAYER_LANG_FILE), chatLog: newChatLog(CHAT_LOG_PATH),
+		auditLog: newAuditLog(AUDIT_LOG_FILE)}
+	m := &Mindustry{servers: servers}
+	m.init()
+	servers.list = []*Mindustry{m}
+	m.in, m.executor, m.serverIsRun = in, newCmdExecutor(in), true
+	return m
+}
+
+func apiRequest(m *Mindustry, userName string, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, API_PREFIX+path, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), webUserKey{}, userName))
+	w := httptest.NewRecorder()
+	(&apiHandler{m}).ServeHTTP(w, r)
+	return w
+}
+
+func TestApiActions(t *testing.T) {
+	in := &recordWriter{}
+	m := newTestMindustry(t, testIni, in)
+	tests := []struct {
+		userName, method, path, body string
+		status                       int
+		sent                         string
+	}{
+		{"super", "POST", "actions/say", `{"message":"hello"}`, 200, "say hello"},
+		{"admin", "POST", "actions/say", `{"message":"hello"}`, 403, ""},
+		{"super", "POST", "actions/kick", `{"name":"griefer"}`, 200, "kick griefer"},
+		{"super", "POST", "actions/ban", `{"name":"griefer"}`, 200, "ban name griefer"},
+		{"super", "POST", "actions/say", `{"message":"a\nexit"}`, 400, ""},
+		{"super", "POST", "actions/fly", `{}`, 404, ""},
+		{"super", "GET", "actions/say", ``, 405, ""},
+		{"super", "POST", "actions/say", `{"message":`, 400, ""},
+		{"super", "POST", "status", ``, 405, ""},
+	}
+	for _, test := range tests {
+		before := len(in.sent())
+		w := apiRequest(m, test.userName, test.method, test.path, test.body)
+		if w.Code != test.status {
+			t.Errorf("%s %s %s as %s = %d %s, want %d", test.method, test.path, test.body, test.userName, w.Code, w.Body, test.status)
+		}
+		sent := in.sent()[before:]
+		if test.sent != "" && !inList(sent, test.sent) {
+			t.Errorf("%s %s: %q not sent:%q", test.path, test.body, test.sent, sent)
+		}
+		if test.sent == "" && len(sent) > 0 && strings.HasPrefix(test.path, "actions/") && test.status != 403 {
+			t.Errorf("%s %s: sent %q", test.path, test.body, sent)
+		}
+	}
+	m.lock.Lock()
+	m.in = nil
+	m.lock.Unlock()
+	if w := apiRequest(m, "super", "POST", "actions/say", `{"message":"hi"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("say with the server down = %d", w.Code)
+	}
+}
+
+func TestApiState(t *testing.T) {
+	m := newTestMindustry(t, testIni, &recordWriter{})
+	m.lock.Lock()
+	m.onlineUser("admin", "AAAAAAAAAAA=")
+	m.onlineUser("guest", "BBBBBBBBBBB=")
+	m.lock.Unlock()
+	var status apiStatus
+	if w := apiRequest(m, "admin", "GET", "status", ""); w.Code != 200 || json.Unmarshal(w.Body.Bytes(), &status) != nil {
+		t.Fatalf("status = %d %s", w.Code, w.Body)
+	}
+	if status.Players != 2 || !status.ServerRun {
+		t.Errorf("status = %+v", status)
+	}
+	var players []apiPlayer
+	json.Unmarshal(apiRequest(m, "admin", "GET", "players", "").Body.Bytes(), &players)
+	roles := map[string]string{}
+	for _, player := range players {
+		roles[player.Name] = player.Role
+	}
+	if len(players) != 2 || roles["admin"] != "admin" || roles["guest"] != "user" {
+		t.Errorf("players = %+v", players)
+	}
+	var admins []apiAdmin
+	json.Unmarshal(apiRequest(m, "admin", "GET", "admins", "").Body.Bytes(), &admins)
+	if len(admins) != 2 || admins[0] != (apiAdmin{"admin", "admin"}) || admins[1] != (apiAdmin{"super", "superAdmin"}) {
+		t.Errorf("admins = %+v", admins)
+	}
+	if w := apiRequest(m, "admin", "GET", "nothing", ""); w.Code != 404 {
+		t.Errorf("unknown path = %d", w.Code)
+	}
+}
+
+func TestApiReload(t *testing.T) {
+	m := newTestMindustry(t, testIni, &recordWriter{})
+	if w := apiRequest(m, "admin", "POST", "config/reload", ""); w.Code != 403 {
+		t.Fatalf("reload as admin = %d", w.Code)
+	}
+	ioutil.WriteFile(configFile, []byte(strings.Replace(testIni, "admins=admin", "admins=admin,helper", 1)), 0666)
+	w := apiRequest(m, "super", "POST", "config/reload", "")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "helper") {
+		t.Fatalf("reload = %d %s", w.Code, w.Body)
+	}
+	ioutil.WriteFile(configFile, []byte(testIni+"votetickCmds=fly\n"), 0666)
+	if w = apiRequest(m, "super", "POST", "config/reload", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid reload = %d %s", w.Code, w.Body)
+	}
+	if !m.isAdmin("helper") {
+		t.Error("invalid reload dropped the old config")
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AUDIT_LOG_FILE records who ran which command, a JSON object per line. The
+// file is only appended to.
+const AUDIT_LOG_FILE = "./logs/audit.log"
+
+// AUDIT_SHOW_MAX is the most entries \audit shows.
+const AUDIT_SHOW_MAX = 20
+
+// where a command came from
+const (
+	AUDIT_CHAT    = "chat"
+	AUDIT_HTTP    = "http"
+	AUDIT_CONSOLE = "console"
+	AUDIT_CRON    = "cron"
+	AUDIT_VOTE    = "vote"
+)
+
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Server  string    `json:"server,omitempty"` //instance name, "" for a single server
+	Actor   string    `json:"actor"`
+	Source  string    `json:"source"`
+	Command string    `json:"command"`
+	Args    string    `json:"args,omitempty"`
+	Result  string    `json:"result"`
+	Remote  string    `json:"remote,omitempty"` //address of an http request
+}
+
+// AuditQuery filters the entries, zero values match everything.
+type AuditQuery struct {
+	Actor   string
+	Source  string
+	Command string
+	Server  string
+	Limit   int
+}
+
+func (this AuditQuery) match(entry AuditEntry) bool {
+	return (this.Actor == "" || strings.EqualFold(this.Actor, entry.Actor)) &&
+		(this.Source == "" || this.Source == entry.Source) &&
+		(this.Command == "" || this.Command == entry.Command) &&
+		(this.Server == "" || this.Server == entry.Server)
+}
+
+// AuditLog is shared by the servers of this process and the web server.
+type AuditLog struct {
+	lock sync.Mutex
+	file string
+}
+
+func newAuditLog(file string) *AuditLog {
+	return &AuditLog{file: file}
+}
+
+func (this *AuditLog) add(entry AuditEntry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	os.MkdirAll(filepath.Dir(this.file), 0777)
+	f, err := os.OpenFile(this.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		supervisorLog.errorf("[audit]%v", err)
+		return
+	}
+	defer f.Close()
+	data, _ := json.Marshal(entry)
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		supervisorLog.errorf("[audit]%v", err)
+	}
+}
+
+// last returns the newest entries matching the query, newest first.
+func (this *AuditLog) last(query AuditQuery) ([]AuditEntry, error) {
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	entries := []AuditEntry{}
+	f, err := os.Open(this.file)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		entry := AuditEntry{}
+		if len(line) > 0 && json.Unmarshal(line, &entry) == nil && query.match(entry) {
+			entries = append(entries, entry)
+			if len(entries) > query.Limit {
+				entries = entries[1:]
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// audit records a command, input is the command line as the user typed it.
+func (this *Mindustry) audit(source string, actor string, input string, result string) {
+	if this.servers == nil || this.servers.auditLog == nil {
+		return
+	}
+	temps := strings.SplitN(strings.TrimSpace(input), " ", 2)
+	entry := AuditEntry{Time: time.Now(), Server: this.id, Actor: actor, Source: source, Command: temps[0], Result: result}
+	if len(temps) > 1 {
+		entry.Args = strings.TrimSpace(temps[1])
+	}
+	this.servers.auditLog.add(entry)
+}
+
+// proc_audit shows the last commands, 5 without a count.
+func (this *Mindustry) proc_audit(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	count := 5
+	if temps := strings.Fields(userInput[len("audit"):]); len(temps) > 0 {
+		n, err := strconv.Atoi(temps[0])
+		if err != nil || n <= 0 || n > AUDIT_SHOW_MAX {
+			this.say(in, "error.cmd_audit_invalid", AUDIT_SHOW_MAX)
+			return false
+		}
+		count = n
+	}
+	if isOnlyCheck {
+		return true
+	}
+	go func() {
+		entries, err := this.servers.auditLog.last(AuditQuery{Limit: count})
+		if err != nil {
+			commandsLog.with(this.id).warnf("[audit]read fail:%v", err)
+		}
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		if len(entries) == 0 {
+			this.tell(this.in, userName, "info.audit_none")
+			return
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := entries[i]
+			this.tell(this.in, userName, "info.audit_entry", entry.Time.Format("01-02 15:04"), entry.Actor, entry.Source,
+				strings.TrimSpace(entry.Command+" "+entry.Args), entry.Result)
+		}
+	}()
+	return true
+}
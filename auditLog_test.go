@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// failWriter is a server stdin that was closed.
+type failWriter struct{}
+
+func (this failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("closed")
+}
+
+func (this failWriter) Close() error {
+	return nil
+}
+
+// nopWriter is a server stdin that takes everything.
+type nopWriter struct{}
+
+func (this nopWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (this nopWriter) Close() error {
+	return nil
+}
+
+// newTestServers has one instance with the admin "admin" and an audit log in
+// a temp dir.
+func newTestServers(t *testing.T) *Servers {
+	servers := &Servers{auditLog: newAuditLog(filepath.Join(t.TempDir(), "audit.log"))}
+	servers.list = []*Mindustry{{servers: servers, online: make(map[string]OnlinePlayer),
+		users: map[string]User{"admin": {"admin", true, false, 1}}}}
+	return servers
+}
+
+func TestConsoleCmdAuditResult(t *testing.T) {
+	servers := newTestServers(t)
+	m := servers.get("")
+	m.consoleCmd(nopWriter{}, "Server", "say hi", AUDIT_CONSOLE)
+	m.consoleCmd(failWriter{}, "Server", "stop", AUDIT_CONSOLE)
+	entries, err := servers.auditLog.last(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ command, args, result string }{{"stop", "", "failed"}, {"say", "hi", "ok"}}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Command != want[i].command || entry.Args != want[i].args || entry.Result != want[i].result || entry.Source != AUDIT_CONSOLE {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+}
+
+func TestProtectAuditsOnce(t *testing.T) {
+	servers := newTestServers(t)
+	auth := newWebAuth(servers)
+	session := &WebSession{id: "s", userName: "admin", csrf: "c", loggedIn: true, expire: time.Now().Add(time.Hour)}
+	auth.sessions[session.id] = session
+	handler := auth.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		method, path string
+		audited      bool
+	}{
+		{"GET", "/files/", false},
+		{"POST", "/files/", true},
+		{"DELETE", "/saves/1", true},
+		{"POST", "/console/cmd", false},
+		{"POST", API_PREFIX + "actions/say", false},
+	}
+	for _, test := range tests {
+		before, _ := servers.auditLog.last(AuditQuery{})
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: session.id})
+		r.Header.Set(CSRF_HEADER, session.csrf)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		after, _ := servers.auditLog.last(AuditQuery{})
+		if audited := len(after) > len(before); audited != test.audited {
+			t.Errorf("%s %s audited = %v, want %v", test.method, test.path, audited, test.audited)
+		}
+	}
+}
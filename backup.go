@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const BACKUP_STATE_FILE = "./config/backup_state.json"
+
+// BackupTarget is where backups are copied to. Keys are slash separated and
+// relative to the target, e.g. "saves/12-20190801-120000.msav.gz".
+type BackupTarget interface {
+	Name() string
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	List(prefix string) ([]string, error)
+}
+
+type BackupCfg struct {
+	target    string
+	dir       string
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+}
+
+func newBackupTarget(cfg BackupCfg) (BackupTarget, error) {
+	switch cfg.target {
+	case "":
+		return nil, nil
+	case "local":
+		if cfg.dir == "" {
+			return nil, errors.New("backup dir is empty")
+		}
+		return &localTarget{cfg.dir}, nil
+	case "s3":
+		if cfg.endpoint == "" || cfg.bucket == "" {
+			return nil, errors.New("backup endpoint or bucket is empty")
+		}
+		region := cfg.region
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &s3Target{
+			endpoint:  strings.TrimRight(cfg.endpoint, "/"),
+			bucket:    cfg.bucket,
+			region:    region,
+			accessKey: cfg.accessKey,
+			secretKey: cfg.secretKey,
+			prefix:    strings.Trim(cfg.prefix, "/"),
+			client:    &http.Client{Timeout: 60 * time.Second},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown backup target:%s", cfg.target)
+}
+
+// localTarget copies backups to a local directory, usually a mounted drive.
+type localTarget struct {
+	dir string
+}
+
+func (this *localTarget) Name() string {
+	return "local:" + this.dir
+}
+func (this *localTarget) Put(key string, data []byte) error {
+	file := filepath.Join(this.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+func (this *localTarget) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(this.dir, filepath.FromSlash(key)))
+}
+func (this *localTarget) List(prefix string) ([]string, error) {
+	keys := []string{}
+	root := filepath.Join(this.dir, filepath.FromSlash(prefix))
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(file, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(this.dir, file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	return keys, err
+}
+
+// s3Target talks to any S3 compatible endpoint with path style requests and
+// AWS signature v4, which is also what MinIO expects.
+type s3Target struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	client    *http.Client
+}
+
+func (this *s3Target) Name() string {
+	return "s3:" + this.endpoint + "/" + this.bucket
+}
+func (this *s3Target) objectKey(key string) string {
+	if this.prefix == "" {
+		return key
+	}
+	return this.prefix + "/" + key
+}
+func (this *s3Target) Put(key string, data []byte) error {
+	resp, err := this.do("PUT", "/"+this.bucket+"/"+this.objectKey(key), nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+func (this *s3Target) Get(key string) ([]byte, error) {
+	resp, err := this.do("GET", "/"+this.bucket+"/"+this.objectKey(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+func (this *s3Target) List(prefix string) ([]string, error) {
+	keys := []string{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", this.objectKey(prefix))
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := this.do("GET", "/"+this.bucket, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			key := content.Key
+			if this.prefix != "" {
+				key = strings.TrimPrefix(key, this.prefix+"/")
+			}
+			keys = append(keys, key)
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func (this *s3Target) do(method string, objPath string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(this.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = objPath
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalHeaders := "host:" + u.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{method, u.EscapedPath(), u.RawQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + this.region + "/s3/aws4_request"
+	reqSum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqSum[:])
+	signingKey := hmacSha256([]byte("AWS4"+this.secretKey), date)
+	signingKey = hmacSha256(signingKey, this.region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+this.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s:%s %s", method, objPath, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Backup copies new saves and wrapper state files to the target. Every
+// object is gzip compressed and has a ".sha256" sidecar with the checksum of
+// the original content, which restore verifies.
+type Backup struct {
+	target    BackupTarget
+	lock      sync.Mutex
+	savePath  string
+	stateFile string
+	prefix    string //instance name + "/" when several servers share the target
+	//source file -> sha256 of the last copy sent to the target
+	state map[string]string
+}
+
+func newBackup(target BackupTarget, savePath string, prefix string) *Backup {
+	stateFile := BACKUP_STATE_FILE
+	if savePath != SAVE_PATH {
+		stateFile = filepath.Join(filepath.Dir(filepath.Clean(savePath)), "backup_state.json")
+	}
+	backup := &Backup{target: target, savePath: savePath, stateFile: stateFile, prefix: prefix, state: make(map[string]string)}
+	if data, err := ioutil.ReadFile(stateFile); err == nil {
+		json.Unmarshal(data, &backup.state)
+	}
+	return backup
+}
+
+func (this *Backup) saveState() {
+	data, _ := json.MarshalIndent(this.state, "", "\t")
+	if err := ioutil.WriteFile(this.stateFile, data, 0666); err != nil {
+		cronLog.warnf("[backup]save state fail:%v", err)
+	}
+}
+
+// BACKUP_REDACTED_KEYS are blanked in the copy of config.ini, the target must
+// not hold the credentials to write to it.
+var BACKUP_REDACTED_KEYS = []string{"accessKey", "secretKey", "apiTokens"}
+
+// redactIni blanks the values of BACKUP_REDACTED_KEYS in an ini file.
+func redactIni(data []byte) []byte {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		index := strings.Index(line, "=")
+		if index <= 0 {
+			continue
+		}
+		if key := strings.TrimSpace(line[:index]); findKey(BACKUP_REDACTED_KEYS, key) != "" {
+			lines[i] = line[:index+1]
+			if strings.HasSuffix(line, "\r") {
+				lines[i] += "\r"
+			}
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func (this *Backup) backupFile(file string, kind string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	if file == configFile {
+		data = redactIni(data)
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	if this.state[file] == checksum {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = filepath.Base(file)
+	zw.Write(data)
+	if err = zw.Close(); err != nil {
+		return "", err
+	}
+	base := filepath.Base(file)
+	ext := path.Ext(base)
+	key := this.prefix + kind + "/" + strings.TrimSuffix(base, ext) + "-" + time.Now().Format("20060102-150405") + ext + ".gz"
+	if err = this.target.Put(key, buf.Bytes()); err != nil {
+		return "", err
+	}
+	if err = this.target.Put(key+".sha256", []byte(checksum+"  "+base+"\n")); err != nil {
+		return "", err
+	}
+	this.state[file] = checksum
+	return key, nil
+}
+
+// backupNew copies every save and state file that changed since its last
+// backup.
+func (this *Backup) backupNew(stateFiles []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	cnt := 0
+	files, _ := ioutil.ReadDir(this.savePath)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), SAVE_EXT) || strings.Contains(f.Name(), "backup") {
+			continue
+		}
+		key, err := this.backupFile(this.savePath+f.Name(), "saves")
+		if err != nil {
+			cronLog.warnf("[backup]%s fail:%v", f.Name(), err)
+		} else if key != "" {
+			cnt++
+		}
+	}
+	for _, file := range stateFiles {
+		if exist, _ := exists(file); !exist {
+			continue
+		}
+		key, err := this.backupFile(file, "state")
+		if err != nil {
+			cronLog.warnf("[backup]%s fail:%v", file, err)
+		} else if key != "" {
+			cnt++
+		}
+	}
+	if cnt > 0 {
+		this.saveState()
+		cronLog.infof("[backup]%d files copied to %s", cnt, this.target.Name())
+	}
+}
+
+// list returns the backup keys (without checksum sidecars), newest first.
+func (this *Backup) list(kind string) ([]string, error) {
+	keys, err := this.target.List(this.prefix + kind + "/")
+	if err != nil {
+		return nil, err
+	}
+	backups := []string{}
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".gz") {
+			backups = append(backups, key)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backupTime(backups[i]) > backupTime(backups[j])
+	})
+	return backups, nil
+}
+
+func backupTime(key string) string {
+	name := strings.TrimSuffix(path.Base(key), ".gz")
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if len(name) < len("20060102-150405") {
+		return ""
+	}
+	return name[len(name)-len("20060102-150405"):]
+}
+
+func (this *Backup) fetch(key string) ([]byte, error) {
+	raw, err := this.target.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	sumFile, err := this.target.Get(key + ".sha256")
+	if err != nil {
+		return nil, fmt.Errorf("checksum of %s not found:%v", key, err)
+	}
+	sum := sha256.Sum256(data)
+	fields := strings.Fields(string(sumFile))
+	if len(fields) == 0 || fields[0] != hex.EncodeToString(sum[:]) {
+		return nil, fmt.Errorf("checksum of %s mismatch", key)
+	}
+	return data, nil
+}
+
+// restore pulls a save backup into the saves directory. The slot defaults to the
+// one the backup was taken from, a slot that already exists is kept as
+// <slot>-backup.msav.
+func (this *Backup) restore(key string, slot string) (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if strings.Contains(key, "..") || path.IsAbs(key) || filepath.IsAbs(key) || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid backup:%s", key)
+	}
+	if !strings.HasPrefix(key, this.prefix+"saves/") {
+		key = this.prefix + "saves/" + key
+	}
+	if slot == "" {
+		name := strings.TrimSuffix(path.Base(key), SAVE_EXT+".gz")
+		if len(name) > len("-20060102-150405") {
+			slot = name[:len(name)-len("-20060102-150405")]
+		}
+	}
+	if !slotNameR.MatchString(slot) {
+		return "", fmt.Errorf("invalid slot:%s", slot)
+	}
+	data, err := this.fetch(key)
+	if err != nil {
+		return "", err
+	}
+	target := slotFileName(this.savePath, slot)
+	tmp := target + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return "", err
+	}
+	if _, err = readMsav(tmp, false); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("%s is not a valid save:%v", key, err)
+	}
+	if exist, _ := exists(target); exist {
+		os.Rename(target, slotFileName(this.savePath, slot+"-backup"))
+	}
+	if err = os.Rename(tmp, target); err != nil {
+		return "", err
+	}
+	commandsLog.infof("[backup]restore %s to slot %s", key, slot)
+	return slot, nil
+}
+
+func (this *Mindustry) proc_backups(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	if this.backup == nil {
+		this.say(in, "error.backup_disabled")
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	//the target may be remote, it is asked without holding the lock
+	backup := this.backup
+	go func() {
+		backups, err := backup.list("saves")
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		if err != nil {
+			this.tell(this.in, userName, "error.backup_fail", err.Error())
+			return
+		}
+		if len(backups) > 10 {
+			backups = backups[:10]
+		}
+		for i, key := range backups {
+			backups[i] = path.Base(key)
+		}
+		this.tell(this.in, userName, "info.backup_list", strings.Join(backups, ","))
+	}()
+	return true
+}
+
+func (this *Mindustry) proc_restore(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	if this.backup == nil {
+		this.say(in, "error.backup_disabled")
+		return false
+	}
+	temps := strings.Fields(userInput)
+	if len(temps) < 2 || len(temps) > 3 {
+		this.say(in, "error.cmd_length_invalid", userInput)
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	slot := ""
+	if len(temps) == 3 {
+		slot = temps[2]
+	}
+	backup := this.backup
+	go func() {
+		slot, err := backup.restore(temps[1], slot)
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		if err != nil {
+			this.tell(this.in, userName, "error.backup_fail", err.Error())
+			return
+		}
+		this.tell(this.in, userName, "info.backup_restored", temps[1], slot)
+	}()
+	return true
+}
+
+// stateFiles are the files the wrapper keeps outside the saves, they are
+// backed up next to them. config.ini is copied with its secrets blanked.
+func (this *Mindustry) stateFiles() []string {
+	files := []string{configFile, MAP_HISTORY_FILE, PLAYER_LANG_FILE, AUDIT_LOG_FILE}
+	if this.config != nil && this.config.cluster.dir != "" {
+		files = append(files, filepath.Join(this.config.cluster.dir, CLUSTER_BANS_FILE))
+	}
+	return files
+}
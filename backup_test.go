@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "saves") + "/"
+	backup := newBackup(&localTarget{filepath.Join(dir, "target")}, savePath, "")
+	save := writeTestMsav(t, 4, 4, -1)
+	key, err := backup.backupFile(save, "saves")
+	if err != nil || key == "" {
+		t.Fatalf("backup:%q %v", key, err)
+	}
+	if err = os.MkdirAll(savePath, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if slot, err := backup.restore(key, "restored"); err != nil || slot != "restored" {
+		t.Fatalf("restore:%q %v", slot, err)
+	}
+	if exist, _ := exists(slotFileName(savePath, "restored")); !exist {
+		t.Fatal("restored save not found")
+	}
+}
+
+func TestBackupRestoreKey(t *testing.T) {
+	backup := newBackup(&localTarget{t.TempDir()}, t.TempDir()+"/", "")
+	tests := []struct {
+		key string
+		err string
+	}{
+		{"../../etc/passwd", "invalid backup"},
+		{"saves/../../config.ini.gz", "invalid backup"},
+		{"/etc/passwd", "invalid backup"},
+		{"saves\\..\\x.msav.gz", "invalid backup"},
+		{"saves/1-20190801-120000.msav.gz", "no such file"},
+	}
+	for _, test := range tests {
+		_, err := backup.restore(test.key, "")
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("restore %s: err = %v, want %q", test.key, err, test.err)
+		}
+	}
+}
+
+func TestStateFiles(t *testing.T) {
+	configFile = "/etc/mindustry/admin.ini"
+	defer func() { configFile = CONFIG_FILE }()
+	m := &Mindustry{config: defaultConfig()}
+	m.config.cluster.dir = "/srv/cluster"
+	files := m.stateFiles()
+	for _, want := range []string{"/etc/mindustry/admin.ini", MAP_HISTORY_FILE, PLAYER_LANG_FILE, AUDIT_LOG_FILE, filepath.Join("/srv/cluster", CLUSTER_BANS_FILE)} {
+		if !inList(files, want) {
+			t.Errorf("%s is not backed up:%v", want, files)
+		}
+	}
+}
+
+func TestBackupRedactsConfig(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { configFile = CONFIG_FILE }()
+	configFile = filepath.Join(dir, "config.ini")
+	ini := "[server]\r\nname=a\r\napiTokens=bot:abc\r\n[backup]\r\ntarget=s3\r\naccessKey = AKID\r\nsecretKey=SECRET\r\n"
+	if err := ioutil.WriteFile(configFile, []byte(ini), 0666); err != nil {
+		t.Fatal(err)
+	}
+	backup := newBackup(&localTarget{filepath.Join(dir, "target")}, filepath.Join(dir, "saves")+"/", "")
+	key, err := backup.backupFile(configFile, "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := backup.fetch(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[server]\r\nname=a\r\napiTokens=\r\n[backup]\r\ntarget=s3\r\naccessKey =\r\nsecretKey=\r\n"
+	if string(data) != want {
+		t.Fatalf("backup of config.ini = %q, want %q", data, want)
+	}
+}
+
+func TestProcRestoreKey(t *testing.T) {
+	dir := t.TempDir()
+	in := &recordWriter{}
+	m := &Mindustry{in: in, backup: newBackup(&localTarget{dir}, dir+"/", ""), i18n: &Locale{messages: map[string]string{"error.backup_fail": "fail:%s"}},
+		online: make(map[string]OnlinePlayer)}
+	m.lock.Lock()
+	ok := m.proc_restore(in, "admin", "restore ../../config.ini.gz", false)
+	m.lock.Unlock()
+	if !ok {
+		t.Fatal("restore not started")
+	}
+	for i := 0; i < 100 && len(in.sent()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := in.sent(); len(sent) != 1 || !strings.Contains(sent[0], "invalid backup") {
+		t.Fatalf("sent %q, want the invalid backup error", sent)
+	}
+}
+
+func TestBackupRestoreWhileBackingUp(t *testing.T) {
+	dir := t.TempDir()
+	savePath := filepath.Join(dir, "saves") + "/"
+	os.MkdirAll(savePath, 0777)
+	backup := newBackup(&localTarget{filepath.Join(dir, "target")}, savePath, "")
+	key, err := backup.backupFile(writeTestMsav(t, 4, 4, -1), "saves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			backup.backupNew(nil)
+		}
+		done <- true
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := backup.restore(key, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
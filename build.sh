@@ -9,6 +9,7 @@ else
         echo "eg:build.sh 1.0"
         exit
 fi
+go test -race -count=1 . || exit 1
 gox -ldflags "-X main._VERSION_=${TAG}" -osarch="windows/amd64"
 gox -ldflags "-X main._VERSION_=${TAG}" -osarch="linux/386"
 gox -ldflags "-X main._VERSION_=${TAG}" -osarch="linux/amd64"
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CHAT_LOG_PATH keeps a file of chat, commands, joins and leaves per day.
+const CHAT_LOG_PATH = "./logs/chat/"
+
+// CHAT_LOG_KEEP_DAYS is how long the daily files are kept.
+const CHAT_LOG_KEEP_DAYS = 90
+
+// CHAT_LASTSAID_MAX is the most messages \lastsaid shows.
+const CHAT_LASTSAID_MAX = 10
+
+const (
+	CHAT_CHAT    = "chat"
+	CHAT_COMMAND = "command"
+	CHAT_JOIN    = "join"
+	CHAT_LEAVE   = "leave"
+)
+
+// ChatEntry is a line of the chat log.
+type ChatEntry struct {
+	Time    time.Time `json:"time"`
+	Server  string    `json:"server,omitempty"` //instance name, "" for a single server
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	Uuid    string    `json:"uuid,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// colorTagR matches the color tags of player names, eg [red] or [#ff0000].
+var colorTagR = regexp.MustCompile("\\[(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)?\\]")
+
+// plainName is a name without terminal colors and color tags.
+func plainName(name string) string {
+	return strings.TrimSpace(colorTagR.ReplaceAllString(StripColor(name), ""))
+}
+
+// ChatQuery filters a search, zero values match everything.
+type ChatQuery struct {
+	Name   string //case insensitive, colors are ignored
+	Server string
+	Type   string
+	Text   string //case insensitive part of the message
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+func (this ChatQuery) match(entry ChatEntry) bool {
+	if this.Name != "" && !strings.EqualFold(plainName(entry.Name), plainName(this.Name)) {
+		return false
+	}
+	if (this.Server != "" && entry.Server != this.Server) || (this.Type != "" && entry.Type != this.Type) {
+		return false
+	}
+	if this.Text != "" && !strings.Contains(strings.ToLower(entry.Message), strings.ToLower(this.Text)) {
+		return false
+	}
+	return (this.From.IsZero() || !entry.Time.Before(this.From)) && (this.To.IsZero() || entry.Time.Before(this.To))
+}
+
+// ChatLog is shared by the servers of this process.
+type ChatLog struct {
+	lock sync.Mutex
+	dir  string
+	day  string
+	file *os.File
+}
+
+func newChatLog(dir string) *ChatLog {
+	return &ChatLog{dir: dir}
+}
+
+func (this *ChatLog) fileName(day string) string {
+	return filepath.Join(this.dir, "chat-"+day+".log")
+}
+
+// add appends an entry to the file of its day, the files of a new day start
+// with removing the ones older than CHAT_LOG_KEEP_DAYS.
+func (this *ChatLog) add(entry ChatEntry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	day := entry.Time.Format("2006-01-02")
+	if day != this.day || this.file == nil {
+		if this.file != nil {
+			this.file.Close()
+			this.file = nil
+		}
+		if err := os.MkdirAll(this.dir, 0777); err != nil {
+			supervisorLog.errorf("[chatLog]%v", err)
+			return
+		}
+		file, err := os.OpenFile(this.fileName(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			supervisorLog.errorf("[chatLog]%v", err)
+			return
+		}
+		this.file, this.day = file, day
+		this.prune(entry.Time)
+	}
+	data, _ := json.Marshal(entry)
+	if _, err := this.file.Write(append(data, '\n')); err != nil {
+		supervisorLog.errorf("[chatLog]%v", err)
+	}
+}
+
+func (this *ChatLog) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, -CHAT_LOG_KEEP_DAYS).Format("2006-01-02")
+	for _, day := range this.days() {
+		if day < oldest {
+			supervisorLog.infof("[chatLog]remove %s", this.fileName(day))
+			os.Remove(this.fileName(day))
+		}
+	}
+}
+
+// days lists the days there are files for, newest first.
+func (this *ChatLog) days() []string {
+	files, _ := filepath.Glob(this.fileName("*"))
+	days := []string{}
+	for _, file := range files {
+		name := filepath.Base(file)
+		days = append(days, name[len("chat-"):len(name)-len(".log")])
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	return days
+}
+
+// search returns the newest entries matching the query, newest first. It
+// does not keep add waiting, a line being written is skipped.
+func (this *ChatLog) search(query ChatQuery) ([]ChatEntry, error) {
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	entries := []ChatEntry{}
+	for _, day := range this.days() {
+		if !query.From.IsZero() && day < query.From.Format("2006-01-02") {
+			break
+		}
+		if !query.To.IsZero() && day > query.To.Format("2006-01-02") {
+			continue
+		}
+		dayEntries, err := readChatFile(this.fileName(day))
+		if err != nil {
+			return entries, err
+		}
+		for i := len(dayEntries) - 1; i >= 0; i-- {
+			if query.match(dayEntries[i]) {
+				entries = append(entries, dayEntries[i])
+				if len(entries) >= query.Limit {
+					return entries, nil
+				}
+			}
+		}
+	}
+	return entries, nil
+}
+
+func readChatFile(fileName string) ([]ChatEntry, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := []ChatEntry{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			entry := ChatEntry{}
+			if json.Unmarshal(line, &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+	}
+}
+
+func (this *Mindustry) logChat(kind string, name string, uuid string, message string) {
+	if this.servers == nil || this.servers.chatLog == nil {
+		return
+	}
+	this.servers.chatLog.add(ChatEntry{time.Now(), this.id, kind, name, uuid, message})
+}
+
+// proc_seen tells when a player was last seen on any server of this process.
+func (this *Mindustry) proc_seen(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	name := strings.TrimSpace(userInput[len("seen"):])
+	if name == "" {
+		this.say(in, "error.cmd_player_name_invalid")
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	if _, ok := this.online[name]; ok {
+		this.say(in, "info.seen_online", name)
+		return true
+	}
+	//the files are read without holding the lock
+	go func() {
+		entries, err := this.servers.chatLog.search(ChatQuery{Name: name, Limit: 1})
+		if err != nil {
+			commandsLog.with(this.id).warnf("[chatLog]search fail:%v", err)
+		}
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		if len(entries) == 0 {
+			this.tell(this.in, userName, "info.seen_never", name)
+			return
+		}
+		entry := entries[0]
+		server, when := entry.Server, entry.Time.Format("2006-01-02 15:04")
+		if server == "" {
+			server = this.name
+		}
+		switch entry.Type {
+		case CHAT_JOIN:
+			this.tell(this.in, userName, "info.seen_join", entry.Name, when, server)
+		case CHAT_LEAVE:
+			this.tell(this.in, userName, "info.seen_leave", entry.Name, when, server)
+		default:
+			this.tell(this.in, userName, "info.seen_chat", entry.Name, when, server, entry.Message)
+		}
+	}()
+	return true
+}
+
+// proc_lastsaid shows the last messages of a player, 3 without a count.
+func (this *Mindustry) proc_lastsaid(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	temps := strings.Fields(userInput[len("lastsaid"):])
+	count := 3
+	if len(temps) > 1 {
+		if n, err := strconv.Atoi(temps[len(temps)-1]); err == nil {
+			count, temps = n, temps[:len(temps)-1]
+		}
+	}
+	if len(temps) == 0 || count <= 0 || count > CHAT_LASTSAID_MAX {
+		this.say(in, "error.cmd_lastsaid_invalid", CHAT_LASTSAID_MAX)
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	name := strings.Join(temps, " ")
+	go func() {
+		entries, err := this.servers.chatLog.search(ChatQuery{Name: name, Type: CHAT_CHAT, Limit: count})
+		if err != nil {
+			commandsLog.with(this.id).warnf("[chatLog]search fail:%v", err)
+		}
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		if len(entries) == 0 {
+			this.tell(this.in, userName, "info.lastsaid_none", name)
+			return
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			this.tell(this.in, userName, "info.lastsaid", entries[i].Time.Format("01-02 15:04"), entries[i].Name, entries[i].Message)
+		}
+	}()
+	return true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlainName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"[red]Bob[]", "Bob"},
+		{"[#ff0000]Al[#0f0]ice ", "Alice"},
+		{"\x1b[31mCarl\x1b[0m", "Carl"},
+		{"Dan[", "Dan["},
+	}
+	for _, test := range tests {
+		if got := plainName(test.name); got != test.want {
+			t.Errorf("plainName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChatLogSearch(t *testing.T) {
+	log := newChatLog(t.TempDir())
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	entries := []ChatEntry{
+		{day1, "", CHAT_JOIN, "[red]Bob", "uuid1", ""},
+		{day1.Add(time.Minute), "", CHAT_CHAT, "[red]Bob", "uuid1", "Hello all"},
+		{day1.Add(2 * time.Minute), "pvp", CHAT_CHAT, "alice", "uuid2", "hi bob"},
+		{day2, "", CHAT_CHAT, "bob", "uuid1", "back again"},
+		{day2.Add(time.Minute), "", CHAT_LEAVE, "bob", "uuid1", ""},
+	}
+	for _, entry := range entries {
+		log.add(entry)
+	}
+	//a line being written is skipped
+	file, _ := os.OpenFile(log.fileName("2026-03-02"), os.O_WRONLY|os.O_APPEND, 0666)
+	file.WriteString(`{"time":"2026-03-02T11:00:00Z","ty`)
+	file.Close()
+
+	tests := []struct {
+		name  string
+		query ChatQuery
+		want  []string
+	}{
+		{"all", ChatQuery{}, []string{"", "back again", "hi bob", "Hello all", ""}},
+		{"name", ChatQuery{Name: "BOB", Type: CHAT_CHAT}, []string{"back again", "Hello all"}},
+		{"limit", ChatQuery{Name: "bob", Limit: 2}, []string{"", "back again"}},
+		{"server", ChatQuery{Server: "pvp"}, []string{"hi bob"}},
+		{"text", ChatQuery{Text: "HELLO"}, []string{"Hello all"}},
+		{"from", ChatQuery{From: day2}, []string{"", "back again"}},
+		{"to", ChatQuery{To: day2}, []string{"hi bob", "Hello all", ""}},
+		{"nobody", ChatQuery{Name: "carl"}, []string{}},
+	}
+	for _, test := range tests {
+		found, err := log.search(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := []string{}
+		for _, entry := range found {
+			messages = append(messages, entry.Message)
+		}
+		if strings.Join(messages, "|") != strings.Join(test.want, "|") || len(messages) != len(test.want) {
+			t.Errorf("%s: %q, want %q", test.name, messages, test.want)
+		}
+	}
+}
+
+func TestChatLogPrune(t *testing.T) {
+	log := newChatLog(t.TempDir())
+	now := time.Now()
+	old := now.AddDate(0, 0, -CHAT_LOG_KEEP_DAYS-1).Format("2006-01-02")
+	kept := now.AddDate(0, 0, -CHAT_LOG_KEEP_DAYS+1).Format("2006-01-02")
+	for _, day := range []string{old, kept} {
+		ioutil.WriteFile(log.fileName(day), []byte("{}\n"), 0666)
+	}
+	log.add(ChatEntry{Time: now, Type: CHAT_CHAT, Name: "bob", Message: "hi"})
+	if days := log.days(); len(days) != 2 || days[0] != now.Format("2006-01-02") || days[1] != kept {
+		t.Fatalf("days = %v", days)
+	}
+}
+
+func TestProcSeenLastsaid(t *testing.T) {
+	in := &recordWriter{}
+	m := newTestMindustry(t, testIni, in)
+	m.online["carl"] = OnlinePlayer{}
+	now := time.Now()
+	m.servers.chatLog.add(ChatEntry{now.Add(-2 * time.Minute), "", CHAT_CHAT, "bob", "", "first"})
+	m.servers.chatLog.add(ChatEntry{now.Add(-time.Minute), "", CHAT_CHAT, "bob", "", "second"})
+	m.servers.chatLog.add(ChatEntry{now, "", CHAT_LEAVE, "bob", "", ""})
+	tests := []struct {
+		input string
+		ok    bool
+		want  []string
+	}{
+		{"seen", false, []string{"say error.cmd_player_name_invalid"}},
+		{"seen carl", true, []string{"say info.seen_online"}},
+		{"seen bob", true, []string{"say info.seen_leave"}},
+		{"seen dan", true, []string{"say info.seen_never"}},
+		{"lastsaid bob 2", true, []string{"say info.lastsaid", "say info.lastsaid"}},
+		{"lastsaid bob 11", false, []string{"say error.cmd_lastsaid_invalid"}},
+		{"lastsaid dan", true, []string{"say info.lastsaid_none"}},
+	}
+	for _, test := range tests {
+		before := len(in.sent())
+		m.lock.Lock()
+		handle := m.proc_seen
+		if strings.HasPrefix(test.input, "lastsaid") {
+			handle = m.proc_lastsaid
+		}
+		ok := handle(in, "admin", test.input, false)
+		m.lock.Unlock()
+		var sent []string
+		for i := 0; i < 100; i++ {
+			if sent = in.sent()[before:]; len(sent) >= len(test.want) {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if ok != test.ok || len(sent) != len(test.want) {
+			t.Fatalf("%s = %v, sent %q", test.input, ok, sent)
+		}
+		for i, line := range sent {
+			if !strings.HasPrefix(line, test.want[i]) {
+				t.Errorf("%s sent %q, want %s", test.input, line, test.want[i])
+			}
+		}
+		if test.input == "lastsaid bob 2" && (!strings.Contains(sent[0], "first") || !strings.Contains(sent[1], "second")) {
+			t.Errorf("lastsaid not oldest first: %q", sent)
+		}
+	}
+}
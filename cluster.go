@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Servers run by other mindustry_admin processes of this host cooperate
+// through the [cluster] dir: every process writes a heartbeat of its servers
+// to servers/<port>.json and reads the others, bans are kept in bans.json.
+const CLUSTER_SYNC_INTERVAL = 10 * time.Second
+
+// CLUSTER_BANS_FILE is the file of the shared dir the bans are kept in.
+const CLUSTER_BANS_FILE = "bans.json"
+
+// CLUSTER_STALE is the age of a heartbeat after which its server is down.
+const CLUSTER_STALE = 3 * CLUSTER_SYNC_INTERVAL
+
+// CLUSTER_LOCK_TIMEOUT is the age of a lock left over by a crashed process.
+const CLUSTER_LOCK_TIMEOUT = 10 * time.Second
+
+type ClusterCfg struct {
+	dir        string //shared by the processes, "" keeps bans and roles to this process
+	host       string //address players connect to, shown by \join
+	shareRoles bool   //the admins of [server] are admins of every server of the cluster
+}
+
+// ClusterServer is the heartbeat of a server, with shareRoles its admins are
+// admins of every server of the cluster.
+type ClusterServer struct {
+	ServerInfo
+	Admins      []string  `json:"admins"`
+	SuperAdmins []string  `json:"superAdmins"`
+	Updated     time.Time `json:"updated"`
+}
+
+// Ban applies to every server. An unban sets Lifted instead of removing it,
+// so a server that was down at the time lifts it later.
+type Ban struct {
+	Name   string     `json:"name,omitempty"`
+	Uuid   string     `json:"uuid,omitempty"`
+	Ip     string     `json:"ip,omitempty"`
+	By     string     `json:"by"`
+	Server string     `json:"server"`
+	Time   time.Time  `json:"time"`
+	Lifted *time.Time `json:"lifted,omitempty"`
+}
+
+func (this Ban) key() string {
+	if this.Uuid != "" {
+		return "id:" + this.Uuid
+	}
+	if this.Ip != "" {
+		return "ip:" + this.Ip
+	}
+	return "name:" + this.Name
+}
+
+func (this Ban) banCmd() string {
+	if this.Uuid != "" {
+		return "ban id " + this.Uuid
+	}
+	if this.Ip != "" {
+		return "ban ip " + this.Ip
+	}
+	return "ban name " + this.Name
+}
+
+// unbanCmd is "" for a ban by name, the server can only lift ip and id bans.
+func (this Ban) unbanCmd() string {
+	if this.Uuid != "" {
+		return "unban " + this.Uuid
+	}
+	if this.Ip != "" {
+		return "unban " + this.Ip
+	}
+	return ""
+}
+
+func (this Ban) matches(target string) bool {
+	return this.Uuid == target || this.Ip == target || (this.Uuid == "" && this.Ip == "" && this.Name == target)
+}
+
+// mergeBan replaces the ban with the same key or adds it.
+func mergeBan(bans []Ban, ban Ban) []Ban {
+	for i := range bans {
+		if bans[i].key() == ban.key() {
+			bans[i] = ban
+			return bans
+		}
+	}
+	return append(bans, ban)
+}
+
+func writeJsonFile(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// readBans returns the bans of the shared dir, none if there is no bans.json.
+func readBans(dir string) ([]Ban, error) {
+	bans := []Ban{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, CLUSTER_BANS_FILE))
+	if os.IsNotExist(err) {
+		return bans, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &bans)
+	return bans, err
+}
+
+// lockStore keeps the other processes out while bans.json is rewritten.
+func lockStore(dir string) (func(), error) {
+	return lockDir(filepath.Join(dir, "bans.lock"))
+}
+
+// lockDir takes a lock shared with other processes by creating the dir lock,
+// mkdir works the same on every system the wrapper is built for.
+func lockDir(lock string) (func(), error) {
+	for i := 0; i < 50; i++ {
+		err := os.Mkdir(lock, 0777)
+		if err == nil {
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > CLUSTER_LOCK_TIMEOUT {
+			supervisorLog.warnf("[cluster]remove stale lock %s", lock)
+			os.Remove(lock)
+			continue
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, errors.New("locked:" + lock)
+}
+
+func updateBans(dir string, update func(bans []Ban) []Ban) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	unlock, err := lockStore(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	bans, err := readBans(dir)
+	if err != nil {
+		return err
+	}
+	return writeJsonFile(filepath.Join(dir, CLUSTER_BANS_FILE), update(bans))
+}
+
+func (this *Servers) clusterCfg() ClusterCfg {
+	mindustry := this.get("")
+	mindustry.lock.Lock()
+	defer mindustry.lock.Unlock()
+	return mindustry.config.cluster
+}
+
+func (this *Servers) syncCluster() {
+	for {
+		this.sync()
+		time.Sleep(CLUSTER_SYNC_INTERVAL)
+	}
+}
+
+// sync writes the heartbeats of the servers of this process, reads the other
+// ones and applies the shared bans, and their admins with shareRoles.
+func (this *Servers) sync() {
+	cfg := this.clusterCfg()
+	if cfg.dir == "" {
+		return
+	}
+	serversDir := filepath.Join(cfg.dir, "servers")
+	if err := os.MkdirAll(serversDir, 0777); err != nil {
+		supervisorLog.warnf("[cluster]%v", err)
+		return
+	}
+	local := make(map[int]bool)
+	for _, mindustry := range this.list {
+		server := mindustry.heartbeat()
+		local[server.Port] = true
+		if err := writeJsonFile(filepath.Join(serversDir, strconv.Itoa(server.Port)+".json"), server); err != nil {
+			supervisorLog.warnf("[cluster]write heartbeat fail:%v", err)
+		}
+	}
+	remote, roles := []ServerInfo{}, make(map[string]int)
+	files, _ := filepath.Glob(filepath.Join(serversDir, "*.json"))
+	for _, file := range files {
+		server := ClusterServer{}
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			err = json.Unmarshal(data, &server)
+		}
+		if err != nil {
+			supervisorLog.warnf("[cluster]read %s fail:%v", file, err)
+			continue
+		}
+		if !cfg.shareRoles {
+			server.Admins, server.SuperAdmins = nil, nil
+		}
+		//roles stay while a server is down, remove its file to drop them
+		for _, admin := range server.Admins {
+			if roles[admin] < 1 {
+				roles[admin] = 1
+			}
+		}
+		for _, superAdmin := range server.SuperAdmins {
+			roles[superAdmin] = 9
+		}
+		if !local[server.Port] && time.Since(server.Updated) < CLUSTER_STALE {
+			remote = append(remote, server.ServerInfo)
+		}
+	}
+	bans, err := readBans(cfg.dir)
+	if err != nil {
+		supervisorLog.warnf("[cluster]read bans fail:%v", err)
+	}
+	this.lock.Lock()
+	this.remote = remote
+	if err == nil {
+		this.bans = bans
+	}
+	bans = append([]Ban{}, this.bans...)
+	this.lock.Unlock()
+	for _, mindustry := range this.list {
+		mindustry.lock.Lock()
+		mindustry.applyRoles(roles)
+		mindustry.applyBans(bans)
+		mindustry.lock.Unlock()
+	}
+}
+
+// shareBan records a ban or unban for every server. The servers of this
+// process apply it at once, the other processes on their next sync.
+func (this *Servers) shareBan(ban Ban) {
+	dir := this.clusterCfg().dir
+	if dir != "" {
+		if err := updateBans(dir, func(bans []Ban) []Ban { return mergeBan(bans, ban) }); err != nil {
+			supervisorLog.errorf("[cluster]save ban fail:%v", err)
+		}
+	}
+	this.lock.Lock()
+	this.bans = mergeBan(this.bans, ban)
+	bans := append([]Ban{}, this.bans...)
+	this.lock.Unlock()
+	for _, mindustry := range this.list {
+		mindustry.lock.Lock()
+		mindustry.applyBans(bans)
+		mindustry.lock.Unlock()
+	}
+}
+
+// findBan returns the ban that is not lifted matching target, nil if none.
+func (this *Servers) findBan(target string) *Ban {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, ban := range this.bans {
+		if ban.Lifted == nil && ban.matches(target) {
+			return &ban
+		}
+	}
+	return nil
+}
+
+// bannedPlayer checks a joining player, a server that has not applied a ban
+// yet or a ban by name would let the player in.
+func (this *Servers) bannedPlayer(name string, uuid string) *Ban {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, ban := range this.bans {
+		if ban.Lifted != nil {
+			continue
+		}
+		if (uuid != "" && ban.Uuid == uuid) || (ban.Uuid == "" && ban.Ip == "" && ban.Name == name) {
+			return &ban
+		}
+	}
+	return nil
+}
+
+// heartbeat shares the admins of [server] with shareRoles, the ones an
+// instance sets in its own section stay on the instance.
+func (this *Mindustry) heartbeat() ClusterServer {
+	info := this.info()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	server := ClusterServer{ServerInfo: info, Admins: []string{}, SuperAdmins: []string{}, Updated: time.Now()}
+	if !this.config.cluster.shareRoles {
+		return server
+	}
+	if !this.config.localAdmins {
+		server.Admins = splitList(this.cfgAdmin)
+	}
+	if !this.config.localSupAdmins {
+		server.SuperAdmins = splitList(this.cfgSuperAdmin)
+	}
+	return server
+}
+
+// serverName is how the server shows up in \servers and the shared bans.
+func (this *Mindustry) serverName() string {
+	if this.id != "" {
+		return this.id
+	}
+	return this.name
+}
+
+// applyRoles makes the admins of every server of the cluster admins here, a
+// role taken away there is taken away here unless config.ini sets it.
+func (this *Mindustry) applyRoles(roles map[string]int) {
+	for name, level := range this.sharedRoles {
+		if roles[name] == level || inList(splitList(this.cfgAdmin), name) || inList(splitList(this.cfgSuperAdmin), name) {
+			continue
+		}
+		if user, ok := this.users[name]; !ok || user.level != level {
+			continue
+		}
+		if _, ok := this.online[name]; ok {
+			this.users[name] = User{name, false, false, 0}
+			if this.in != nil {
+				this.execCmd(this.in, "unadmin "+name)
+			}
+		} else {
+			delete(this.users, name)
+		}
+	}
+	this.sharedRoles = roles
+	for name, level := range roles {
+		if this.users[name].level >= level {
+			continue
+		}
+		this.addUser(name)
+		if level == 9 {
+			this.addSuperAdmin(name)
+		} else {
+			this.addAdmin(name)
+		}
+		if _, ok := this.online[name]; ok && this.in != nil {
+			this.execCmd(this.in, "admin "+name)
+		}
+	}
+}
+
+// applyBans sends the bans the running server has not seen yet, a ban by name
+// only works while the player is online and is otherwise checked on join.
+func (this *Mindustry) applyBans(bans []Ban) {
+	if this.in == nil || !this.serverIsRun {
+		return
+	}
+	for _, ban := range bans {
+		banned := ban.Lifted == nil
+		if sent, ok := this.bansSent[ban.key()]; ok && sent == banned {
+			continue
+		}
+		if !banned {
+			if cmd := ban.unbanCmd(); cmd != "" {
+				this.execCmd(this.in, cmd)
+			}
+		} else if ban.Uuid != "" || ban.Ip != "" {
+			this.execCmd(this.in, ban.banCmd())
+		} else if _, ok := this.online[ban.Name]; ok {
+			this.execCmd(this.in, ban.banCmd())
+		} else {
+			continue
+		}
+		this.bansSent[ban.key()] = banned
+	}
+}
+
+// checkBan kicks a joining player that is banned on another server.
+func (this *Mindustry) checkBan(in io.WriteCloser, name string, uuid string) bool {
+	ban := this.servers.bannedPlayer(name, uuid)
+	if ban == nil {
+		return false
+	}
+	supervisorLog.with(this.id).infof("[cluster]%s is banned on %s by %s", name, ban.Server, ban.By)
+	this.execCmd(in, "kick "+name)
+	return true
+}
+
+// proc_ban bans on every server, a ban by name of an online player is shared
+// by its id so it still applies after a rename.
+func (this *Mindustry) proc_ban(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	temps := strings.SplitN(strings.TrimSpace(userInput[len("ban"):]), " ", 2)
+	if len(temps) < 2 || strings.TrimSpace(temps[1]) == "" || (temps[0] != "id" && temps[0] != "name" && temps[0] != "ip") {
+		this.say(in, "error.cmd_ban_invalid")
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	target := strings.TrimSpace(temps[1])
+	ban := Ban{By: userName, Server: this.serverName(), Time: time.Now()}
+	switch temps[0] {
+	case "id":
+		ban.Uuid = target
+		for _, player := range this.online {
+			if player.Uuid == target {
+				ban.Name = player.Name
+			}
+		}
+	case "name":
+		ban.Name, ban.Uuid = target, this.online[target].Uuid
+	case "ip":
+		ban.Ip = target
+	}
+	this.execCmd(in, userInput)
+	this.bansSent[ban.key()] = true
+	go this.servers.shareBan(ban)
+	return true
+}
+
+// proc_unban lifts a ban on every server, bans by name are lifted by name.
+func (this *Mindustry) proc_unban(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	target := strings.TrimSpace(userInput[len("unban"):])
+	if target == "" {
+		this.say(in, "error.cmd_unban_invalid")
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	ban := Ban{By: userName, Server: this.serverName(), Time: time.Now()}
+	if found := this.servers.findBan(target); found != nil {
+		ban = *found
+	} else if net.ParseIP(target) != nil {
+		ban.Ip = target
+	} else {
+		ban.Uuid = target
+	}
+	now := time.Now()
+	ban.Lifted = &now
+	if cmd := ban.unbanCmd(); cmd != "" {
+		this.execCmd(in, cmd)
+	}
+	this.bansSent[ban.key()] = false
+	this.say(in, "info.unbanned", target)
+	go this.servers.shareBan(ban)
+	return true
+}
+
+func (this *Mindustry) proc_join(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	name := strings.TrimSpace(userInput[len("join"):])
+	if name == "" {
+		this.say(in, "error.cmd_join_name_invalid")
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	go func() {
+		infos := this.servers.infos()
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		for _, info := range infos {
+			if !strings.EqualFold(info.Name, name) && !strings.EqualFold(plainName(info.Title), name) && strconv.Itoa(info.Port) != name {
+				continue
+			}
+			if info.Host == "" {
+				this.tell(this.in, userName, "info.join_server_port", userName, info.Port)
+			} else {
+				this.tell(this.in, userName, "info.join_server", userName, info.Host, info.Port)
+			}
+			return
+		}
+		this.tell(this.in, userName, "error.cmd_join_not_found", name)
+	}()
+	return true
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCluster runs the instances pvp and pve of a process sharing dir,
+// both servers are running.
+func newTestCluster(t *testing.T, dir string, shareRoles string) (*Servers, *Mindustry, *Mindustry) {
+	ini := `[server]
+admins=a
+superAdmins=s
+[server:pvp]
+dir=pvp
+port=6000
+[server:pve]
+dir=pve
+port=6001
+superAdmins=local
+[cluster]
+dir=` + dir + `
+shareRoles=` + shareRoles + `
+`
+	m := newTestMindustry(t, ini, &recordWriter{})
+	os.Mkdir("pvp", 0777)
+	os.Mkdir("pve", 0777)
+	servers := m.servers
+	servers.list = nil
+	for _, id := range []string{"pvp", "pve"} {
+		instance := &Mindustry{servers: servers, id: id}
+		instance.init()
+		in := &recordWriter{}
+		instance.in, instance.executor, instance.serverIsRun = in, newCmdExecutor(in), true
+		servers.list = append(servers.list, instance)
+	}
+	return servers, servers.list[0], servers.list[1]
+}
+
+func writeHeartbeat(t *testing.T, dir string, server ClusterServer) {
+	os.MkdirAll(filepath.Join(dir, "servers"), 0777)
+	if err := writeJsonFile(filepath.Join(dir, "servers", fmt.Sprintf("%d.json", server.Port)), server); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readHeartbeat(t *testing.T, dir string, port int) ClusterServer {
+	server := ClusterServer{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "servers", fmt.Sprintf("%d.json", port)))
+	if err == nil {
+		err = json.Unmarshal(data, &server)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestClusterRoles(t *testing.T) {
+	tests := []struct {
+		shareRoles string
+		pvpAdmins  []string //admins and super admins pvp has after a sync
+		pveAdmins  []string
+		heartbeat  []string //admins and super admins in the heartbeat of pve
+		remote     int
+	}{
+		{"true", []string{"Server", "a", "old", "r", "rs", "s"}, []string{"Server", "a", "local", "old", "r", "rs", "s"}, []string{"a"}, 1},
+		{"false", []string{"Server", "a", "s"}, []string{"Server", "a", "local"}, []string{}, 1},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		writeHeartbeat(t, dir, ClusterServer{ServerInfo{Name: "remote", Port: 7000}, []string{"r"}, []string{"rs"}, time.Now()})
+		//a server that is down keeps its roles
+		writeHeartbeat(t, dir, ClusterServer{ServerInfo{Name: "down", Port: 7001}, []string{"old"}, nil, time.Now().Add(-time.Hour)})
+		servers, pvp, pve := newTestCluster(t, dir, test.shareRoles)
+		servers.sync()
+		for _, check := range []struct {
+			m    *Mindustry
+			want []string
+		}{{pvp, test.pvpAdmins}, {pve, test.pveAdmins}} {
+			check.m.lock.Lock()
+			admins := []string{}
+			for name, user := range check.m.users {
+				if user.isAdmin {
+					admins = append(admins, name)
+				}
+			}
+			check.m.lock.Unlock()
+			sort.Strings(admins)
+			if !reflect.DeepEqual(admins, check.want) {
+				t.Errorf("shareRoles=%s: %s admins %v, want %v", test.shareRoles, check.m.id, admins, check.want)
+			}
+		}
+		heartbeat := readHeartbeat(t, dir, 6001)
+		if got := append(heartbeat.Admins, heartbeat.SuperAdmins...); !reflect.DeepEqual(got, test.heartbeat) || heartbeat.Name != "pve" {
+			t.Errorf("shareRoles=%s: pve heartbeat %+v", test.shareRoles, heartbeat)
+		}
+		if len(servers.remote) != test.remote || servers.remote[0].Port != 7000 {
+			t.Errorf("shareRoles=%s: remote %+v", test.shareRoles, servers.remote)
+		}
+	}
+}
+
+func TestClusterRolesRemoved(t *testing.T) {
+	dir := t.TempDir()
+	writeHeartbeat(t, dir, ClusterServer{ServerInfo{Name: "remote", Port: 7000}, []string{"r"}, nil, time.Now()})
+	servers, pvp, _ := newTestCluster(t, dir, "true")
+	servers.sync()
+	pvp.lock.Lock()
+	pvp.online["r"] = OnlinePlayer{Name: "r"}
+	pvp.lock.Unlock()
+	os.Remove(filepath.Join(dir, "servers", "7000.json"))
+	servers.sync()
+	pvp.lock.Lock()
+	defer pvp.lock.Unlock()
+	if pvp.users["r"].isAdmin || !inList(pvp.in.(*recordWriter).sent(), "unadmin r") {
+		t.Fatalf("r is still admin: %+v, sent %q", pvp.users["r"], pvp.in.(*recordWriter).sent())
+	}
+}
+
+func TestClusterBans(t *testing.T) {
+	dir := t.TempDir()
+	_, pvp, _ := newTestCluster(t, dir, "false")
+	other, otherPvp, otherPve := newTestCluster(t, dir, "false")
+
+	pvp.lock.Lock()
+	pvp.proc_ban(pvp.in, "s", "ban id AAAAAAAAAAA=", false)
+	pvp.lock.Unlock()
+	var bans []Ban
+	for i := 0; i < 100 && len(bans) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		bans, _ = readBans(dir)
+	}
+	if len(bans) != 1 || bans[0].Uuid != "AAAAAAAAAAA=" || bans[0].Server != "pvp" || bans[0].By != "s" {
+		t.Fatalf("bans.json = %+v", bans)
+	}
+	other.sync()
+	for _, m := range []*Mindustry{otherPvp, otherPve} {
+		if sent := m.in.(*recordWriter).sent(); !inList(sent, "ban id AAAAAAAAAAA=") {
+			t.Errorf("%s of the other process sent %q", m.id, sent)
+		}
+	}
+	if ban := other.bannedPlayer("griefer", "AAAAAAAAAAA="); ban == nil {
+		t.Error("joining player not found banned")
+	}
+
+	otherPve.lock.Lock()
+	otherPve.proc_unban(otherPve.in, "s", "unban AAAAAAAAAAA=", false)
+	otherPve.lock.Unlock()
+	for i := 0; i < 100 && bans[0].Lifted == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		bans, _ = readBans(dir)
+	}
+	pvp.servers.sync()
+	if sent := pvp.in.(*recordWriter).sent(); bans[0].Lifted == nil || !inList(sent, "unban AAAAAAAAAAA=") {
+		t.Errorf("unban not shared: %+v, sent %q", bans, sent)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bans.lock")); !os.IsNotExist(err) {
+		t.Errorf("bans.lock left: %v", err)
+	}
+}
+
+func TestLockStore(t *testing.T) {
+	dir := t.TempDir()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ban := Ban{Uuid: fmt.Sprintf("uuid%d", i)}
+			if err := updateBans(dir, func(bans []Ban) []Ban { return mergeBan(bans, ban) }); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if bans, err := readBans(dir); err != nil || len(bans) != 10 {
+		t.Fatalf("%d bans of 10 written: %v", len(bans), err)
+	}
+
+	//another process holds the lock for a moment
+	lock := filepath.Join(dir, "bans.lock")
+	os.Mkdir(lock, 0777)
+	start := time.Now()
+	time.AfterFunc(200*time.Millisecond, func() { os.Remove(lock) })
+	unlock, err := lockStore(dir)
+	if err != nil || time.Since(start) < 200*time.Millisecond {
+		t.Fatalf("lock taken after %v: %v", time.Since(start), err)
+	}
+	unlock()
+
+	//a crashed process left it
+	os.Mkdir(lock, 0777)
+	old := time.Now().Add(-2 * CLUSTER_LOCK_TIMEOUT)
+	os.Chtimes(lock, old, old)
+	if unlock, err = lockStore(dir); err != nil {
+		t.Fatalf("stale lock: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Fatalf("lock left: %v", err)
+	}
+}
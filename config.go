@@ -0,0 +1,862 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/robfig/cron"
+)
+
+const CONFIG_FILE = "config.ini"
+const LOCALE_PATH = "./locale/"
+
+// configKeys are the sections and keys config.ini may contain, anything else
+// is reported as a typo.
+var configKeys = map[string][]string{
+	"server": {"name", "jarPath", "notice", "language", "admins", "superAdmins", "normCmds",
+		"adminCmds", "superAdminCmds", "votetickCmds", "maxUploadSize", "apiTokens", "whisperCmd", "adminUuids"},
+	"schedule": {"hourTask", "tenMinTask", "reconcileTask"},
+	"backup":   {"target", "dir", "endpoint", "bucket", "region", "accessKey", "secretKey", "prefix"},
+	"cluster":  {"dir", "host", "shareRoles"},
+}
+
+// instanceKeys may be set in a [server:NAME] section, each such section is a
+// server instance and keys it does not set come from [server]. Its schedule
+// can be changed in [schedule:NAME].
+var instanceKeys = []string{"dir", "port", "mode", "name", "jarPath", "notice", "language",
+	"admins", "superAdmins", "normCmds", "adminCmds", "superAdminCmds", "votetickCmds"}
+
+// sectionKeys returns the keys of a section, nil if the section is unknown.
+func sectionKeys(section string) []string {
+	if keys, ok := configKeys[section]; ok {
+		return keys
+	}
+	if strings.HasPrefix(section, "server:") && len(section) > len("server:") {
+		return instanceKeys
+	}
+	if strings.HasPrefix(section, "schedule:") && len(section) > len("schedule:") {
+		return configKeys["schedule"]
+	}
+	return nil
+}
+
+// runtimeKeys are the [server] keys \config can change.
+var runtimeKeys = []string{"name", "notice", "language", "admins", "superAdmins",
+	"normCmds", "adminCmds", "superAdminCmds", "votetickCmds"}
+
+var apiTokenHashR = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// uuidR is the uuid the server prints for a player, eg AAAAAAAAAAA=.
+var uuidR = regexp.MustCompile("^[A-Za-z0-9+/]{8,}={0,2}$")
+
+// Config is what loadConfig reads from config.ini. A reload reads a new
+// Config first and only applies it if it is valid.
+type Config struct {
+	instance       string
+	dir            string //working directory of the server
+	port           int
+	mode           string
+	name           string
+	jarPath        string
+	notice         string
+	language       string
+	admins         string
+	superAdmins    string
+	localAdmins    bool //admins and superAdmins set in [server:NAME] are not
+	localSupAdmins bool //shared with the cluster
+	normCmds       string
+	adminCmds      string
+	superAdminCmds string
+	voteCmds       string
+	whisperCmd     string
+	maxUploadSize  int64
+	apiTokens      map[string]string //sha256 of token -> admin name
+	adminUuids     string            //name:uuid list, \weblogin of these admins needs the uuid
+	schedule       []ScheduleTask
+	backup         BackupCfg
+	cluster        ClusterCfg
+}
+
+// ConfigError is a problem found in config.ini, the server refuses to start
+// with a fatal one.
+type ConfigError struct {
+	File  string
+	Line  int //0: the whole file
+	Fatal bool
+	Msg   string
+}
+
+func (this ConfigError) Error() string {
+	level := "warning"
+	if this.Fatal {
+		level = "error"
+	}
+	if this.Line == 0 {
+		return fmt.Sprintf("%s: %s: %s", this.File, level, this.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s", this.File, this.Line, level, this.Msg)
+}
+
+func hasFatal(errs []ConfigError) bool {
+	for _, err := range errs {
+		if err.Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, entries are trimmed and empty ones
+// dropped, so "help, maps," is help and maps.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// field returns the value of a [server] key in runtimeKeys.
+func (this *Config) field(key string) *string {
+	switch key {
+	case "name":
+		return &this.name
+	case "notice":
+		return &this.notice
+	case "language":
+		return &this.language
+	case "admins":
+		return &this.admins
+	case "superAdmins":
+		return &this.superAdmins
+	case "normCmds":
+		return &this.normCmds
+	case "adminCmds":
+		return &this.adminCmds
+	case "superAdminCmds":
+		return &this.superAdminCmds
+	case "votetickCmds":
+		return &this.voteCmds
+	}
+	return nil
+}
+
+func inList(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		jarPath:       "server-release.jar",
+		language:      "en_US",
+		maxUploadSize: 1 << 20,
+		apiTokens:     make(map[string]string),
+		schedule:      append([]ScheduleTask{}, scheduleTasks...),
+	}
+}
+
+type iniValue struct {
+	value string
+	file  string //config file or the override it comes from
+	line  int
+}
+
+// iniFile keeps the line of every value so problems can point at it.
+type iniFile struct {
+	name      string
+	sections  map[string]map[string]iniValue
+	instances []string //[server:NAME] sections in file order
+	instance  string   //get looks in the sections of this instance first
+	errs      []ConfigError
+}
+
+func (this *iniFile) fail(line int, fatal bool, format string, v ...interface{}) {
+	this.errs = append(this.errs, ConfigError{this.name, line, fatal, fmt.Sprintf(format, v...)})
+}
+
+// failAt reports a problem with a value, nil is the whole file.
+func (this *iniFile) failAt(at *iniValue, fatal bool, format string, v ...interface{}) {
+	if at == nil {
+		at = &iniValue{file: this.name}
+	}
+	this.errs = append(this.errs, ConfigError{at.file, at.line, fatal, fmt.Sprintf(format, v...)})
+}
+
+func findKey(keys []string, key string) string {
+	for _, k := range keys {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return ""
+}
+
+// parseIni reads config.ini in place of github.com/larspensjo/config, which
+// keeps no line numbers and merges duplicate and unknown keys silently, so
+// -check-config could not point at a problem. It reads the format config.ini
+// has always used: [section], key=value and ; or # comments on their own line.
+func parseIni(fileName string, data string) *iniFile {
+	ini := &iniFile{name: fileName, sections: make(map[string]map[string]iniValue)}
+	section, known := "", false
+	for i, line := range strings.Split(data, "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				ini.fail(lineNo, true, "section header %q is missing ]", line)
+				section, known = "", false
+				continue
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			known = sectionKeys(section) != nil
+			if !known {
+				ini.fail(lineNo, false, "unknown section [%s], its keys are ignored", section)
+			} else if ini.sections[section] == nil {
+				ini.sections[section] = make(map[string]iniValue)
+				if strings.HasPrefix(section, "server:") {
+					ini.instances = append(ini.instances, section[len("server:"):])
+				}
+			}
+			continue
+		}
+		index := strings.Index(line, "=")
+		if index <= 0 {
+			ini.fail(lineNo, true, "expected key=value, got %q", line)
+			continue
+		}
+		key, value := strings.TrimSpace(line[:index]), strings.TrimSpace(line[index+1:])
+		if section == "" {
+			ini.fail(lineNo, true, "%s is not in a section", key)
+			continue
+		}
+		if !known {
+			continue
+		}
+		if findKey(sectionKeys(section), key) != key {
+			if k := findKey(sectionKeys(section), key); k != "" {
+				ini.fail(lineNo, false, "unknown key %s in [%s], did you mean %s?", key, section, k)
+			} else {
+				ini.fail(lineNo, false, "unknown key %s in [%s]", key, section)
+			}
+			continue
+		}
+		if prev, ok := ini.sections[section][key]; ok {
+			ini.fail(lineNo, false, "%s is already set on line %d, this one wins", key, prev.line)
+		}
+		ini.sections[section][key] = iniValue{value, fileName, lineNo}
+	}
+	ini.applyOverrides()
+	return ini
+}
+
+// fromInstance tells if get takes the key from the section of the instance.
+func (this *iniFile) fromInstance(section string, key string) bool {
+	if this.instance == "" {
+		return false
+	}
+	//an override of [server] still wins over the file value of an instance
+	base, isOverride := this.sections[section][key]
+	isOverride = isOverride && base.line == 0
+	v, ok := this.sections[section+":"+this.instance][key]
+	return ok && (v.line == 0 || !isOverride)
+}
+
+// get sets value if the key is set and returns where it was set.
+func (this *iniFile) get(section string, key string, value *string) *iniValue {
+	if this.fromInstance(section, key) {
+		v := this.sections[section+":"+this.instance][key]
+		*value = v.value
+		return &v
+	}
+	v, ok := this.sections[section][key]
+	if !ok {
+		return nil
+	}
+	*value = v.value
+	return &v
+}
+
+// getList is get for comma separated lists, the value is stored normalized.
+func (this *iniFile) getList(section string, key string, value *string) *iniValue {
+	at := this.get(section, key, value)
+	if at != nil {
+		list := strings.TrimSpace(*value)
+		if list != "" && (strings.Contains(","+list+",", ",,") || strings.HasSuffix(list, ",")) {
+			this.failAt(at, false, "%s has an empty entry", key)
+		}
+		*value = strings.Join(splitList(*value), ",")
+	}
+	return at
+}
+
+// readConfig reads and checks the config of an instance, "" without
+// [server:NAME] sections. Keys that are missing keep their default, the
+// config is nil if there is a fatal problem.
+func readConfig(fileName string, instance string) (*Config, []ConfigError) {
+	ini, err := readIni(fileName)
+	if err != nil {
+		return nil, []ConfigError{{fileName, 0, true, err.Error()}}
+	}
+	ini.instance = instance
+	return buildConfig(ini)
+}
+
+// readInstances returns the instance names of the config file, an empty
+// list means a single server.
+func readInstances(fileName string) ([]string, error) {
+	ini, err := readIni(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ini.instances, nil
+}
+
+// readIni reads an ini file with the overrides applied, a missing file only
+// has the overrides.
+func readIni(fileName string) (*iniFile, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		ini := parseIni(fileName, "")
+		ini.fail(0, false, "not found, using the default config")
+		return ini, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseIni(fileName, string(data)), nil
+}
+
+// parseConfig is readConfig for the content of a file.
+func parseConfig(fileName string, data string, instance string) (*Config, []ConfigError) {
+	ini := parseIni(fileName, data)
+	ini.instance = instance
+	return buildConfig(ini)
+}
+
+func buildConfig(ini *iniFile) (*Config, []ConfigError) {
+	cfg := defaultConfig()
+	cfg.instance = ini.instance
+	if ini.instance != "" {
+		if at := ini.get("server", "dir", &cfg.dir); at == nil || cfg.dir == "" {
+			ini.failAt(at, true, "[server:%s] needs dir, the working directory of the server", ini.instance)
+		} else if info, err := os.Stat(cfg.dir); err != nil || !info.IsDir() {
+			ini.failAt(at, true, "dir %s is not a directory", cfg.dir)
+		}
+		port := ""
+		if at := ini.get("server", "port", &port); at == nil {
+			ini.failAt(at, true, "[server:%s] needs port", ini.instance)
+		} else if cfg.port, _ = strconv.Atoi(port); cfg.port <= 0 || cfg.port > 65535 {
+			ini.failAt(at, true, "port %q is not a valid port", port)
+		}
+		ini.get("server", "mode", &cfg.mode)
+	}
+	ini.get("server", "name", &cfg.name)
+	ini.get("server", "notice", &cfg.notice)
+	ini.getList("server", "admins", &cfg.admins)
+	ini.getList("server", "superAdmins", &cfg.superAdmins)
+	cfg.localAdmins = ini.fromInstance("server", "admins")
+	cfg.localSupAdmins = ini.fromInstance("server", "superAdmins")
+
+	if at := ini.get("server", "jarPath", &cfg.jarPath); at != nil {
+		jarPath := cfg.jarPath
+		if cfg.dir != "" && !filepath.IsAbs(jarPath) {
+			jarPath = filepath.Join(cfg.dir, jarPath)
+		}
+		if exist, _ := exists(jarPath); !exist {
+			ini.failAt(at, false, "jarPath %s does not exist", cfg.jarPath)
+		}
+	}
+	languageAt := ini.get("server", "language", &cfg.language)
+	if cfg.language == "" {
+		ini.failAt(languageAt, false, "language is empty, using en_US")
+		cfg.language = "en_US"
+	}
+	if exist, _ := exists(LOCALE_PATH + cfg.language + ".json"); !exist {
+		ini.failAt(languageAt, true, "language %s not found in %s", cfg.language, LOCALE_PATH)
+	}
+
+	cmds := make(map[string]bool)
+	for _, key := range []string{"normCmds", "adminCmds", "superAdminCmds"} {
+		value := ""
+		ini.getList("server", key, &value)
+		for _, cmd := range splitList(value) {
+			cmds[cmd] = true
+		}
+		switch key {
+		case "normCmds":
+			cfg.normCmds = value
+		case "adminCmds":
+			cfg.adminCmds = value
+		case "superAdminCmds":
+			cfg.superAdminCmds = value
+		}
+	}
+	voteAt := ini.getList("server", "votetickCmds", &cfg.voteCmds)
+	for _, cmd := range splitList(cfg.voteCmds) {
+		if !cmds[cmd] {
+			ini.failAt(voteAt, true, "votetickCmds %s is not in normCmds, adminCmds or superAdminCmds", cmd)
+		}
+	}
+
+	ini.get("server", "whisperCmd", &cfg.whisperCmd)
+
+	uuidAt := ini.getList("server", "adminUuids", &cfg.adminUuids)
+	for _, entry := range splitList(cfg.adminUuids) {
+		temps := strings.Split(entry, ":")
+		if len(temps) != 2 || temps[0] == "" || !uuidR.MatchString(temps[1]) {
+			ini.failAt(uuidAt, true, "adminUuids entry %q is not name:uuid", entry)
+		}
+	}
+
+	maxUploadSize := ""
+	if at := ini.get("server", "maxUploadSize", &maxUploadSize); at != nil {
+		if size, err := strconv.ParseInt(maxUploadSize, 10, 64); err == nil && size > 0 {
+			cfg.maxUploadSize = size << 10
+		} else {
+			ini.failAt(at, true, "maxUploadSize %q is not a positive number of KB", maxUploadSize)
+		}
+	}
+	apiTokens := ""
+	tokenAt := ini.get("server", "apiTokens", &apiTokens)
+	for _, token := range splitList(apiTokens) {
+		temps := strings.Split(token, ":")
+		if len(temps) != 2 || !apiTokenHashR.MatchString(strings.TrimSpace(temps[1])) {
+			ini.failAt(tokenAt, true, "apiTokens entry %q is not name:sha256, create one with -api-token", token)
+			continue
+		}
+		cfg.apiTokens[strings.TrimSpace(temps[1])] = strings.TrimSpace(temps[0])
+	}
+
+	for i := range cfg.schedule {
+		task := &cfg.schedule[i]
+		if at := ini.get("schedule", task.Name, &task.Spec); at != nil {
+			if _, err := cron.Parse(task.Spec); err != nil {
+				ini.failAt(at, true, "%s %q is not a cron spec:%v", task.Name, task.Spec, err)
+			}
+		}
+	}
+
+	targetAt := ini.get("backup", "target", &cfg.backup.target)
+	ini.get("backup", "dir", &cfg.backup.dir)
+	ini.get("backup", "endpoint", &cfg.backup.endpoint)
+	ini.get("backup", "bucket", &cfg.backup.bucket)
+	ini.get("backup", "region", &cfg.backup.region)
+	ini.get("backup", "accessKey", &cfg.backup.accessKey)
+	ini.get("backup", "secretKey", &cfg.backup.secretKey)
+	ini.get("backup", "prefix", &cfg.backup.prefix)
+	if _, err := newBackupTarget(cfg.backup); err != nil {
+		ini.failAt(targetAt, true, "%v", err)
+	}
+
+	if at := ini.get("cluster", "dir", &cfg.cluster.dir); at != nil && cfg.cluster.dir != "" {
+		if info, err := os.Stat(cfg.cluster.dir); os.IsNotExist(err) {
+			ini.failAt(at, false, "cluster dir %s does not exist, it will be created", cfg.cluster.dir)
+		} else if err != nil || !info.IsDir() {
+			ini.failAt(at, true, "cluster dir %s is not a directory", cfg.cluster.dir)
+		}
+	}
+	ini.get("cluster", "host", &cfg.cluster.host)
+	shareRoles := ""
+	if at := ini.get("cluster", "shareRoles", &shareRoles); at != nil && shareRoles != "" {
+		var err error
+		if cfg.cluster.shareRoles, err = strconv.ParseBool(shareRoles); err != nil {
+			ini.failAt(at, true, "shareRoles %q is not true or false", shareRoles)
+		}
+	}
+
+	sort.SliceStable(ini.errs, func(i, j int) bool {
+		return ini.errs[i].Line < ini.errs[j].Line
+	})
+	if hasFatal(ini.errs) {
+		return nil, ini.errs
+	}
+	return cfg, ini.errs
+}
+
+// checkConfig prints every problem of config.ini for -check-config and
+// returns false if the server would refuse to start.
+func checkConfig(fileName string) bool {
+	errs := checkInstances(fileName)
+	for _, err := range errs {
+		fmt.Println(err.Error())
+	}
+	if hasFatal(errs) {
+		return false
+	}
+	fmt.Printf("%s ok\n", fileName)
+	return true
+}
+
+// checkInstances reads the config of every instance, instances must not
+// share a port or a directory.
+func checkInstances(fileName string) []ConfigError {
+	instances, err := readInstances(fileName)
+	if err != nil {
+		return []ConfigError{{fileName, 0, true, err.Error()}}
+	}
+	if len(instances) == 0 {
+		_, errs := readConfig(fileName, "")
+		return errs
+	}
+	errs, seen := []ConfigError{}, make(map[string]bool)
+	ports, dirs := make(map[int]string), make(map[string]string)
+	for _, instance := range instances {
+		cfg, instanceErrs := readConfig(fileName, instance)
+		for _, err := range instanceErrs {
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				errs = append(errs, err)
+			}
+		}
+		if cfg == nil {
+			continue
+		}
+		if other, ok := ports[cfg.port]; ok {
+			errs = append(errs, ConfigError{fileName, 0, true, fmt.Sprintf("[server:%s] and [server:%s] use port %d", other, instance, cfg.port)})
+		}
+		ports[cfg.port] = instance
+		dir, _ := filepath.Abs(cfg.dir)
+		if other, ok := dirs[dir]; ok {
+			errs = append(errs, ConfigError{fileName, 0, true, fmt.Sprintf("[server:%s] and [server:%s] use dir %s", other, instance, cfg.dir)})
+		}
+		dirs[dir] = instance
+	}
+	return errs
+}
+
+func diffList(key string, oldValue string, newValue string) []string {
+	oldSet := make(map[string]bool)
+	for _, item := range splitList(oldValue) {
+		oldSet[item] = true
+	}
+	added, removed := []string{}, []string{}
+	for _, item := range splitList(newValue) {
+		if !oldSet[item] {
+			added = append(added, item)
+		}
+		delete(oldSet, item)
+	}
+	for item := range oldSet {
+		removed = append(removed, item)
+	}
+	sort.Strings(removed)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	diff := key + ":"
+	for _, item := range added {
+		diff += " +" + item
+	}
+	for _, item := range removed {
+		diff += " -" + item
+	}
+	return []string{diff}
+}
+
+func diffValue(key string, oldValue string, newValue string) []string {
+	if oldValue == newValue {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s: %q -> %q", key, oldValue, newValue)}
+}
+
+// diffConfig describes what a reload changes, secrets are not printed.
+func diffConfig(old *Config, cfg *Config) []string {
+	diff := []string{}
+	diff = append(diff, diffValue("dir(restart needed)", old.dir, cfg.dir)...)
+	diff = append(diff, diffValue("port(restart needed)", strconv.Itoa(old.port), strconv.Itoa(cfg.port))...)
+	diff = append(diff, diffValue("mode", old.mode, cfg.mode)...)
+	diff = append(diff, diffValue("name", old.name, cfg.name)...)
+	diff = append(diff, diffValue("jarPath", old.jarPath, cfg.jarPath)...)
+	diff = append(diff, diffValue("notice", old.notice, cfg.notice)...)
+	diff = append(diff, diffValue("language", old.language, cfg.language)...)
+	diff = append(diff, diffList("admins", old.admins, cfg.admins)...)
+	diff = append(diff, diffList("superAdmins", old.superAdmins, cfg.superAdmins)...)
+	diff = append(diff, diffList("normCmds", old.normCmds, cfg.normCmds)...)
+	diff = append(diff, diffList("adminCmds", old.adminCmds, cfg.adminCmds)...)
+	diff = append(diff, diffList("superAdminCmds", old.superAdminCmds, cfg.superAdminCmds)...)
+	diff = append(diff, diffList("votetickCmds", old.voteCmds, cfg.voteCmds)...)
+	diff = append(diff, diffValue("whisperCmd", old.whisperCmd, cfg.whisperCmd)...)
+	diff = append(diff, diffList("adminUuids", old.adminUuids, cfg.adminUuids)...)
+	diff = append(diff, diffValue("maxUploadSize", strconv.FormatInt(old.maxUploadSize>>10, 10), strconv.FormatInt(cfg.maxUploadSize>>10, 10))...)
+	oldTokens, newTokens := []string{}, []string{}
+	for hash, name := range old.apiTokens {
+		oldTokens = append(oldTokens, name+":"+hash)
+	}
+	for hash, name := range cfg.apiTokens {
+		newTokens = append(newTokens, name+":"+hash)
+	}
+	sort.Strings(oldTokens)
+	sort.Strings(newTokens)
+	if strings.Join(oldTokens, ",") != strings.Join(newTokens, ",") {
+		diff = append(diff, fmt.Sprintf("apiTokens: %d -> %d", len(oldTokens), len(newTokens)))
+	}
+	for i := range cfg.schedule {
+		diff = append(diff, diffValue("schedule."+cfg.schedule[i].Name, old.schedule[i].Spec, cfg.schedule[i].Spec)...)
+	}
+	if old.backup != cfg.backup {
+		diff = append(diff, "backup: changed")
+	}
+	diff = append(diff, diffValue("cluster.dir", old.cluster.dir, cfg.cluster.dir)...)
+	diff = append(diff, diffValue("cluster.host", old.cluster.host, cfg.cluster.host)...)
+	diff = append(diff, diffValue("cluster.shareRoles", strconv.FormatBool(old.cluster.shareRoles), strconv.FormatBool(cfg.cluster.shareRoles))...)
+	return diff
+}
+
+// loadConfig reads config.ini at startup and exits on a fatal problem.
+func (this *Mindustry) loadConfig() {
+	locales, localeErrs := loadLocales(LOCALE_PATH)
+	for _, err := range localeErrs {
+		supervisorLog.with(this.id).warnf("[i18n]%v", err)
+	}
+	this.locales = locales
+	this.config = defaultConfig()
+	cfg, errs := readConfig(configFile, this.id)
+	for _, err := range errs {
+		supervisorLog.with(this.id).warnf("[ini]%s", err.Error())
+	}
+	if cfg == nil {
+		supervisorLog.with(this.id).fatalf("[ini]%s is invalid, fix the errors above(check with -check-config)", configFile)
+	}
+	if this.id != "" {
+		this.dir, this.port = cfg.dir, cfg.port
+	}
+	if cfg.adminUuids == "" {
+		supervisorLog.with(this.id).warnf("[web]adminUuids is empty, \\weblogin only checks the player name")
+	}
+	if cfg.whisperCmd == "" {
+		supervisorLog.with(this.id).warnf("[lang]whisperCmd is empty, answers are said to everyone in %s and \\lang has no effect", cfg.language)
+	}
+	this.applyConfig(cfg)
+}
+
+// applyConfig runs with the Mindustry lock held.
+func (this *Mindustry) applyConfig(cfg *Config) {
+	old := this.config
+	this.config = cfg
+	if cfg.name != "" {
+		this.name = cfg.name
+	}
+	this.jarPath = cfg.jarPath
+	if cfg.instance != "" {
+		this.mode = cfg.mode
+	}
+	this.notice = cfg.notice
+	this.cfgAdmin = cfg.admins
+	this.cfgSuperAdmin = cfg.superAdmins
+	this.cfgNormCmds = cfg.normCmds
+	this.cfgAdminCmds = cfg.adminCmds
+	this.cfgSuperAdminCmds = cfg.superAdminCmds
+	this.cfgVoteCmds = cfg.voteCmds
+	this.whisperCmd = cfg.whisperCmd
+	this.apiTokens = cfg.apiTokens
+	this.schedule = cfg.schedule
+	atomic.StoreInt64(&maxUploadSize, cfg.maxUploadSize)
+	supervisorLog.with(this.id).infof("[ini]lanage cfg:%s", cfg.language)
+	this.i18n = this.locales.get(cfg.language)
+
+	//the roles of the config and the ones shared by the cluster, admins that
+	//have neither any more become normal users again
+	roles := make(map[string]int)
+	for name, level := range this.sharedRoles {
+		roles[name] = level
+	}
+	for _, admin := range splitList(cfg.admins) {
+		if roles[admin] < 1 {
+			roles[admin] = 1
+		}
+	}
+	for _, supAdmin := range splitList(cfg.superAdmins) {
+		roles[supAdmin] = 9
+	}
+	wasAdmin := make(map[string]bool)
+	for name, user := range this.users {
+		wasAdmin[name] = user.isAdmin
+		if name == "Server" || !user.isAdmin || roles[name] == user.level {
+			continue
+		}
+		if _, ok := this.online[name]; ok {
+			this.users[name] = User{name, false, false, 0}
+			if roles[name] == 0 && this.in != nil {
+				this.execCmd(this.in, "unadmin "+name)
+			}
+		} else {
+			delete(this.users, name)
+		}
+	}
+	supervisorLog.with(this.id).infof("[ini]found admins:%v", splitList(cfg.admins))
+	supervisorLog.with(this.id).infof("[ini]found supAdmins:%v", splitList(cfg.superAdmins))
+	for name, level := range roles {
+		if this.users[name].level == level {
+			continue
+		}
+		this.addUser(name)
+		if level == 9 {
+			this.addSuperAdmin(name)
+		} else {
+			this.addAdmin(name)
+		}
+		if _, ok := this.online[name]; ok && !wasAdmin[name] && this.in != nil {
+			this.execCmd(this.in, "admin "+name)
+		}
+	}
+
+	this.cmds = make(map[string]Cmd)
+	for _, cmd := range splitList(cfg.superAdminCmds) {
+		this.cmds[cmd] = Cmd{cmd, 9, false}
+	}
+	for _, cmd := range splitList(cfg.adminCmds) {
+		this.cmds[cmd] = Cmd{cmd, 1, false}
+	}
+	for _, cmd := range splitList(cfg.normCmds) {
+		this.cmds[cmd] = Cmd{cmd, 0, false}
+	}
+	for _, cmd := range splitList(cfg.voteCmds) {
+		if c, ok := this.cmds[cmd]; ok {
+			c.isVote = true
+			this.cmds[cmd] = c
+		}
+	}
+	this.warnLocaleGaps(cfg.language)
+
+	if old == nil || old.backup != cfg.backup {
+		this.backup = nil
+		if target, _ := newBackupTarget(cfg.backup); target != nil {
+			supervisorLog.with(this.id).infof("[ini]backup target:%s", target.Name())
+			prefix := ""
+			if this.id != "" {
+				prefix = this.id + "/"
+			}
+			this.backup = newBackup(target, this.savePath(), prefix)
+		}
+	}
+	if this.in != nil {
+		if old != nil && old.name != cfg.name && cfg.name != "" {
+			this.execCmd(this.in, "name "+cfg.name)
+		}
+		if old == nil || fmt.Sprint(old.schedule) != fmt.Sprint(cfg.schedule) {
+			this.startSchedule(this.in)
+		}
+	}
+}
+
+// reloadConfig reads config.ini again and applies it if it is valid, it
+// runs with the Mindustry lock held.
+func (this *Mindustry) reloadConfig() ([]string, error) {
+	cfg, errs := readConfig(configFile, this.id)
+	msgs := []string{}
+	for _, err := range errs {
+		supervisorLog.with(this.id).warnf("[ini]%s", err.Error())
+		if err.Fatal {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if cfg == nil {
+		supervisorLog.with(this.id).errorf("[ini]reload fail, keep the old config")
+		return nil, errors.New(strings.Join(msgs, "; "))
+	}
+	//translations can be changed without a restart too
+	if locales, localeErrs := loadLocales(LOCALE_PATH); len(localeErrs) == 0 {
+		this.locales = locales
+	} else {
+		supervisorLog.with(this.id).warnf("[i18n]keep the old translations:%v", localeErrs)
+	}
+	diff := diffConfig(this.config, cfg)
+	this.applyConfig(cfg)
+	supervisorLog.with(this.id).infof("[ini]reloaded:%v", diff)
+	return diff, nil
+}
+
+// startSchedule (re)starts the cron tasks for the running server.
+func (this *Mindustry) startSchedule(in io.WriteCloser) {
+	if this.cron != nil {
+		this.cron.Stop()
+	}
+	tasks := map[string]func(in io.WriteCloser){
+		"hourTask":   this.hourTask,
+		"tenMinTask": this.tenMinTask,
+		"reconcileTask": func(in io.WriteCloser) {
+			this.reconcileTask()
+		},
+	}
+	this.cron = cron.New()
+	for _, task := range this.schedule {
+		taskFunc := tasks[task.Name]
+		this.cron.AddFunc(task.Spec, func() {
+			this.lock.Lock()
+			defer this.lock.Unlock()
+			taskFunc(in)
+		})
+	}
+	this.cron.Start()
+}
+
+func (this *Mindustry) stopSchedule() {
+	if this.cron != nil {
+		this.cron.Stop()
+		this.cron = nil
+	}
+}
+
+func (this *Mindustry) proc_reload(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	if isOnlyCheck {
+		return true
+	}
+	diff, err := this.reloadConfig()
+	if err != nil {
+		this.say(in, "error.config_invalid", err.Error())
+		return false
+	}
+	if len(diff) == 0 {
+		this.say(in, "info.config_unchanged")
+	} else {
+		this.say(in, "info.config_reloaded", strings.Join(diff, "; "))
+	}
+	return true
+}
+
+// proc_config shows or changes a [server] key, the change is written back to
+// config.ini.
+func (this *Mindustry) proc_config(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	temps := strings.SplitN(strings.TrimSpace(userInput), " ", 3)
+	if len(temps) < 2 || !inList(runtimeKeys, temps[1]) {
+		this.say(in, "error.config_key_invalid", strings.Join(runtimeKeys, ","))
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	key := temps[1]
+	if len(temps) < 3 {
+		value := *this.config.field(key)
+		if key == "name" {
+			value = this.name
+		}
+		this.say(in, "info.config_value", key, value)
+		return true
+	}
+	if err := this.updateConfig("server", key, strings.TrimSpace(temps[2])); err != nil {
+		this.say(in, "error.config_not_saved", err.Error())
+		return false
+	}
+	this.say(in, "info.config_saved", key)
+	return true
+}
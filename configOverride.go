@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ENV_PREFIX starts the environment variables that override config.ini, eg
+// MINDUSTRY_ADMIN_SERVER_NAME for name in [server],
+// MINDUSTRY_ADMIN_SERVER_SUPER_ADMIN_CMDS for superAdminCmds and
+// MINDUSTRY_ADMIN_SERVER_PVP_PORT for port in [server:pvp].
+const ENV_PREFIX = "MINDUSTRY_ADMIN_"
+
+// configFile is config.ini or the file given with -config.
+var configFile = CONFIG_FILE
+
+// configOverride replaces a value of config.ini. Values are taken in the
+// order default < config file < environment < -set, the last one wins.
+type configOverride struct {
+	section string
+	key     string
+	value   string
+	source  string
+}
+
+var configOverrides []configOverride
+
+// overrideErrs are the problems found while collecting the overrides.
+var overrideErrs []ConfigError
+
+// configDefaults are the values of the keys that have one, for -print-config.
+var configDefaults = map[string]string{
+	"server.jarPath":       "server-release.jar",
+	"server.language":      "en_US",
+	"server.maxUploadSize": "1024",
+	"cluster.shareRoles":   "false",
+}
+
+func init() {
+	for _, task := range scheduleTasks {
+		configDefaults["schedule."+task.Name] = task.Spec
+	}
+}
+
+// envName turns section.key into its environment variable, camel case keys
+// are split with underscores and the other characters of an instance
+// section become underscores.
+func envName(section string, key string) string {
+	name := ENV_PREFIX + strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, section) + "_"
+	for i, r := range key {
+		if i > 0 && unicode.IsUpper(r) {
+			name += "_"
+		}
+		name += string(unicode.ToUpper(r))
+	}
+	return name
+}
+
+// loadEnvOverrides adds the environment variables of every known key, other
+// variables with the prefix are reported as typos. The instances are those
+// of configFile, so it is called once -config is parsed.
+func loadEnvOverrides() {
+	known := make(map[string]bool)
+	sections := make([]string, 0, len(configKeys))
+	for section := range configKeys {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	if instances, err := readInstances(configFile); err == nil {
+		for _, instance := range instances {
+			sections = append(sections, "server:"+instance, "schedule:"+instance)
+		}
+	}
+	for _, section := range sections {
+		for _, key := range sectionKeys(section) {
+			name := envName(section, key)
+			if known[name] {
+				overrideErrs = append(overrideErrs, ConfigError{"env " + name, 0, false, fmt.Sprintf("is also the variable of %s.%s, only the first is used", section, key)})
+				continue
+			}
+			known[name] = true
+			if value, ok := os.LookupEnv(name); ok {
+				configOverrides = append(configOverrides, configOverride{section, key, strings.TrimSpace(value), "env " + name})
+			}
+		}
+	}
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if strings.HasPrefix(name, ENV_PREFIX) && !known[name] {
+			overrideErrs = append(overrideErrs, ConfigError{"env " + name, 0, false, "unknown config key"})
+		}
+	}
+}
+
+// setFlags collects -set section.key=value, the flag can be repeated.
+type setFlags []configOverride
+
+func (this *setFlags) String() string {
+	sources := []string{}
+	for _, o := range *this {
+		sources = append(sources, o.source)
+	}
+	return strings.Join(sources, " ")
+}
+
+func (this *setFlags) Set(value string) error {
+	index := strings.Index(value, "=")
+	dot := strings.Index(value, ".")
+	if index < 0 || dot <= 0 || dot > index {
+		return errors.New("expected section.key=value")
+	}
+	*this = append(*this, configOverride{
+		section: strings.TrimSpace(value[:dot]),
+		key:     strings.TrimSpace(value[dot+1 : index]),
+		value:   strings.TrimSpace(value[index+1:]),
+		source:  "-set " + value[:index],
+	})
+	return nil
+}
+
+// applyOverrides puts the overrides over the values read from the file.
+func (this *iniFile) applyOverrides() {
+	this.errs = append(this.errs, overrideErrs...)
+	for _, o := range configOverrides {
+		if findKey(sectionKeys(o.section), o.key) != o.key {
+			this.errs = append(this.errs, ConfigError{o.source, 0, false, fmt.Sprintf("unknown key %s.%s", o.section, o.key)})
+			continue
+		}
+		if this.sections[o.section] == nil {
+			this.sections[o.section] = make(map[string]iniValue)
+		}
+		this.sections[o.section][o.key] = iniValue{value: o.value, file: o.source}
+	}
+}
+
+// overrideOf returns the override that wins for section.key, if any.
+func overrideOf(section string, key string) *configOverride {
+	var found *configOverride
+	for i := range configOverrides {
+		if configOverrides[i].section == section && configOverrides[i].key == key {
+			found = &configOverrides[i]
+		}
+	}
+	return found
+}
+
+// printConfig prints the merged configuration with the source of every value
+// for -print-config, secrets are masked. An instance shows every key it
+// runs with, the ones it does not set come from [server] or [schedule].
+func printConfig(w io.Writer, fileName string) error {
+	ini, err := readIni(fileName)
+	if err != nil {
+		return err
+	}
+	sections := []string{"server", "schedule", "backup", "cluster"}
+	for _, instance := range ini.instances {
+		sections = append(sections, "server:"+instance, "schedule:"+instance)
+	}
+	for _, section := range sections {
+		fmt.Fprintf(w, "[%s]\n", section)
+		base := section
+		ini.instance = ""
+		if index := strings.Index(section, ":"); index >= 0 {
+			base, ini.instance = section[:index], section[index+1:]
+		}
+		for _, key := range sectionKeys(section) {
+			value, source := configDefaults[base+"."+key], "default"
+			if v := ini.get(base, key, &value); v != nil {
+				source = v.file
+				if v.line > 0 {
+					source = fmt.Sprintf("%s:%d", v.file, v.line)
+				}
+			} else if ini.instance != "" && value == "" {
+				continue
+			}
+			if (key == "accessKey" || key == "secretKey") && value != "" {
+				value = "***"
+			}
+			fmt.Fprintf(w, "%s=%s\t;%s\n", key, value, source)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testInstancesIni = `[server]
+name=base
+jarPath=server.jar
+[server:pvp]
+dir=/srv/pvp
+port=6000
+[server:survival-1]
+dir=/srv/survival
+port=6001
+name=survival
+`
+
+func TestEnvInstanceOverrides(t *testing.T) {
+	defer func(file string) { configFile, configOverrides, overrideErrs = file, nil, nil }(configFile)
+	configFile = filepath.Join(t.TempDir(), "config.ini")
+	if err := ioutil.WriteFile(configFile, []byte(testInstancesIni), 0666); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MINDUSTRY_ADMIN_SERVER_PVP_PORT", "7000")
+	t.Setenv("MINDUSTRY_ADMIN_SERVER_SURVIVAL_1_MODE", "survival")
+	t.Setenv("MINDUSTRY_ADMIN_SERVER_NAME", "env")
+	t.Setenv("MINDUSTRY_ADMIN_SERVER_PVP_PROT", "7000")
+	loadEnvOverrides()
+	configOverrides = append(configOverrides, configOverride{"server:pvp", "dir", "/srv/set", "-set server:pvp.dir"})
+	ini, err := readIni(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		instance, section, key string
+		want, source           string
+	}{
+		{"pvp", "server", "port", "7000", "env MINDUSTRY_ADMIN_SERVER_PVP_PORT"},
+		{"pvp", "server", "dir", "/srv/set", "-set server:pvp.dir"},
+		{"pvp", "server", "name", "env", "env MINDUSTRY_ADMIN_SERVER_NAME"},
+		{"survival-1", "server", "mode", "survival", "env MINDUSTRY_ADMIN_SERVER_SURVIVAL_1_MODE"},
+		{"survival-1", "server", "port", "6001", configFile},
+		{"survival-1", "server", "name", "env", "env MINDUSTRY_ADMIN_SERVER_NAME"},
+	}
+	for _, test := range tests {
+		ini.instance = test.instance
+		value := ""
+		at := ini.get(test.section, test.key, &value)
+		if at == nil || value != test.want || at.file != test.source {
+			t.Errorf("[%s:%s] %s = %q from %v, want %q from %s", test.section, test.instance, test.key, value, at, test.want, test.source)
+		}
+	}
+	found := false
+	for _, e := range ini.errs {
+		found = found || strings.Contains(e.File, "MINDUSTRY_ADMIN_SERVER_PVP_PROT")
+	}
+	if !found {
+		t.Errorf("typo not reported:%v", ini.errs)
+	}
+}
+
+func TestPrintConfigInstances(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.ini")
+	if err := ioutil.WriteFile(file, []byte(testInstancesIni), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := printConfig(&out, file); err != nil {
+		t.Fatal(err)
+	}
+	sections := make(map[string][]string)
+	section := ""
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, "[") {
+			section = line
+		} else if line != "" {
+			sections[section] = append(sections[section], line)
+		}
+	}
+	tests := []struct {
+		section, line string
+	}{
+		{"[server:pvp]", "port=6000\t;" + file + ":6"},
+		{"[server:pvp]", "name=base\t;" + file + ":2"},
+		{"[server:pvp]", "jarPath=server.jar\t;" + file + ":3"},
+		{"[server:survival-1]", "name=survival\t;" + file + ":10"},
+		{"[server:survival-1]", "language=en_US\t;default"},
+		{"[schedule:pvp]", "hourTask=" + configDefaults["schedule.hourTask"] + "\t;default"},
+	}
+	for _, test := range tests {
+		if !inList(sections[test.section], test.line) {
+			t.Errorf("%s has no %q:%q", test.section, test.line, sections[test.section])
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// setIniValue sets section.key in the content of an ini file. The other
+// lines, comments and the order stay as they are, a missing key is added at
+// the end of its section and a missing section at the end of the file.
+func setIniValue(data string, section string, key string, value string) string {
+	eol := "\n"
+	if strings.Contains(data, "\r\n") {
+		eol = "\r\n"
+	}
+	lines := strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n")
+	trailing := len(lines) > 0 && lines[len(lines)-1] == ""
+	if trailing {
+		lines = lines[:len(lines)-1]
+	}
+	current, keyLine, lastLine := "", -1, -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			if current == section {
+				lastLine = i
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		lastLine = i
+		if index := strings.Index(line, "="); index > 0 && strings.TrimSpace(line[:index]) == key {
+			keyLine = i
+		}
+	}
+	newLine := key + "=" + value
+	switch {
+	case keyLine >= 0:
+		lines[keyLine] = newLine
+	case lastLine >= 0:
+		lines = append(lines[:lastLine+1], append([]string{newLine}, lines[lastLine+1:]...)...)
+	default:
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section+"]", newLine)
+	}
+	if trailing || data == "" {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, eol)
+}
+
+// writeConfigFile replaces config.ini, the previous file is kept with the
+// suffix .bak.
+func writeConfigFile(fileName string, data string) error {
+	if old, err := ioutil.ReadFile(fileName); err == nil {
+		if err = ioutil.WriteFile(fileName+".bak", old, 0666); err != nil {
+			return err
+		}
+	}
+	tmp := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(data), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// configLocks keeps the servers of this process from writing a config file at
+// the same time, each file has its own lock.
+var configLocks = struct {
+	sync.Mutex
+	files map[string]*sync.Mutex
+}{files: make(map[string]*sync.Mutex)}
+
+// lockConfigFile takes the lock of the file in this process and the lock dir
+// fileName.lock, which keeps out other processes sharing the file.
+func lockConfigFile(fileName string) (func(), error) {
+	if abs, err := filepath.Abs(fileName); err == nil {
+		fileName = abs
+	}
+	configLocks.Lock()
+	lock, ok := configLocks.files[fileName]
+	if !ok {
+		lock = &sync.Mutex{}
+		configLocks.files[fileName] = lock
+	}
+	configLocks.Unlock()
+	lock.Lock()
+	unlockDir, err := lockDir(fileName + ".lock")
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlockDir()
+		lock.Unlock()
+	}, nil
+}
+
+// updateConfig writes a runtime change back to config.ini and applies the
+// file as a reload would, a change that makes the file invalid is rejected.
+// It runs with the Mindustry lock held, the file is read and written under
+// lockConfigFile so that no change of another server is lost.
+func (this *Mindustry) updateConfig(section string, key string, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("value must be a single line")
+	}
+	if o := overrideOf(section, key); o != nil {
+		return fmt.Errorf("%s.%s is set by %s", section, key, o.source)
+	}
+	unlock, err := lockConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	//an instance changes the value where it comes from: its own section, or
+	//[server] for a value it inherits, which the other instances inheriting
+	//it see too. A value nobody sets goes to its own section.
+	if this.id != "" && section == "server" {
+		sections := parseIni(configFile, string(data)).sections
+		_, own := sections["server:"+this.id][key]
+		if _, inherited := sections["server"][key]; own || !inherited {
+			section = "server:" + this.id
+			if o := overrideOf(section, key); o != nil {
+				return fmt.Errorf("%s.%s is set by %s", section, key, o.source)
+			}
+		}
+	}
+	newData := setIniValue(string(data), section, key, value)
+	cfg, errs := parseConfig(configFile, newData, this.id)
+	if cfg == nil {
+		msgs := []string{}
+		for _, err := range errs {
+			if err.Fatal {
+				msgs = append(msgs, err.Error())
+			}
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	if err = writeConfigFile(configFile, newData); err != nil {
+		return err
+	}
+	commandsLog.with(this.id).infof("[ini]%s.%s=%s saved:%v", section, key, value, diffConfig(this.config, cfg))
+	this.applyConfig(cfg)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetIniValue(t *testing.T) {
+	tests := []struct {
+		name                string
+		data                string
+		section, key, value string
+		want                string
+	}{
+		{"replace", "; top\n[server]\n; the name\nname=old ; not a comment\nnotice=hi\n",
+			"server", "name", "new",
+			"; top\n[server]\n; the name\nname=new\nnotice=hi\n"},
+		{"add to section", "[server]\nname=a\n; end of server\n\n[backup]\ntarget=local\n",
+			"server", "notice", "hi",
+			"[server]\nname=a\nnotice=hi\n; end of server\n\n[backup]\ntarget=local\n"},
+		{"add section", "[server]\nname=a\n",
+			"server:pvp", "port", "6000",
+			"[server]\nname=a\n\n[server:pvp]\nport=6000\n"},
+		{"instance section only", "[server]\nname=a\n[server:pvp]\nname=b\n",
+			"server:pvp", "name", "c",
+			"[server]\nname=a\n[server:pvp]\nname=c\n"},
+		{"commented key", "[server]\n;name=a\n",
+			"server", "name", "b",
+			"[server]\nname=b\n;name=a\n"},
+		{"crlf", "[server]\r\nname=a\r\n",
+			"server", "name", "b",
+			"[server]\r\nname=b\r\n"},
+		{"empty file", "",
+			"server", "name", "a",
+			"[server]\nname=a\n"},
+	}
+	for _, test := range tests {
+		if got := setIniValue(test.data, test.section, test.key, test.value); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUpdateConfigLocked(t *testing.T) {
+	m := newTestMindustry(t, testIni, &recordWriter{})
+	other := &Mindustry{servers: m.servers}
+	other.init()
+	wg := sync.WaitGroup{}
+	for _, test := range []struct {
+		m   *Mindustry
+		key string
+	}{{m, "notice"}, {other, "name"}} {
+		wg.Add(1)
+		go func(m *Mindustry, key string) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				m.lock.Lock()
+				if err := m.updateConfig("server", key, fmt.Sprintf("%s%d", key, i)); err != nil {
+					t.Error(err)
+				}
+				m.lock.Unlock()
+			}
+		}(test.m, test.key)
+	}
+	wg.Wait()
+	cfg, _ := readConfig(configFile, "")
+	if cfg.notice != "notice19" || cfg.name != "name19" {
+		t.Fatalf("notice %q, name %q: a change was lost", cfg.notice, cfg.name)
+	}
+
+	//another process holds the lock for a moment, a crashed one left it
+	lock := configFile + ".lock"
+	os.Mkdir(lock, 0777)
+	time.AfterFunc(200*time.Millisecond, func() { os.Remove(lock) })
+	m.lock.Lock()
+	err := m.updateConfig("server", "notice", "waited")
+	m.lock.Unlock()
+	if err != nil || m.config.notice != "waited" {
+		t.Fatalf("waiting for the lock: %v, notice %q", err, m.config.notice)
+	}
+	os.Mkdir(lock, 0777)
+	old := time.Now().Add(-2 * CLUSTER_LOCK_TIMEOUT)
+	os.Chtimes(lock, old, old)
+	m.lock.Lock()
+	err = m.updateConfig("server", "notice", "stale")
+	m.lock.Unlock()
+	if _, statErr := os.Stat(lock); err != nil || !os.IsNotExist(statErr) {
+		t.Fatalf("stale lock: %v, %v", err, statErr)
+	}
+}
+
+func TestUpdateConfigInstance(t *testing.T) {
+	const ini = `[server]
+admins=a
+superAdmins=s
+[server:pvp]
+dir=pvp
+port=6000
+[server:pve]
+dir=pve
+port=6001
+admins=b
+`
+	m := newTestMindustry(t, ini, &recordWriter{})
+	os.Mkdir("pvp", 0777)
+	os.Mkdir("pve", 0777)
+	tests := []struct {
+		instance, key, value string
+		section              string //where the value is written
+	}{
+		{"pvp", "admins", "a,x", "server"},
+		{"pve", "admins", "b,y", "server:pve"},
+		{"pvp", "notice", "hi", "server:pvp"},
+		{"pve", "superAdmins", "s,z", "server"},
+	}
+	for _, test := range tests {
+		instance := &Mindustry{servers: m.servers, id: test.instance}
+		instance.init()
+		instance.lock.Lock()
+		err := instance.updateConfig("server", test.key, test.value)
+		instance.lock.Unlock()
+		if err != nil {
+			t.Fatalf("%s %s: %v", test.instance, test.key, err)
+		}
+		ini, _ := readIni(configFile)
+		if got := ini.sections[test.section][test.key].value; got != test.value {
+			t.Errorf("%s %s: [%s] has %q, want %q", test.instance, test.key, test.section, got, test.value)
+		}
+	}
+	for _, test := range []struct{ instance, admins string }{{"pvp", "a,x"}, {"pve", "b,y"}} {
+		cfg, _ := readConfig(configFile, test.instance)
+		if cfg.admins != test.admins || cfg.superAdmins != "s,z" {
+			t.Errorf("%s: admins %q, super admins %q", test.instance, cfg.admins, cfg.superAdmins)
+		}
+	}
+	if ini, _ := readIni(configFile); len(ini.sections["server:pvp"]) != 3 {
+		t.Errorf("inherited keys copied to [server:pvp]: %v", ini.sections["server:pvp"])
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordWriter is a server stdin that keeps the commands written to it.
+type recordWriter struct {
+	lock sync.Mutex
+	cmds []string
+}
+
+func (this *recordWriter) Write(p []byte) (int, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.cmds = append(this.cmds, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func (this *recordWriter) Close() error {
+	return nil
+}
+
+func (this *recordWriter) sent() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]string{}, this.cmds...)
+}
+
+func TestApplyConfigRoles(t *testing.T) {
+	in := &recordWriter{}
+	m := &Mindustry{in: in, config: defaultConfig(), online: make(map[string]OnlinePlayer),
+		users: make(map[string]User), sharedRoles: map[string]int{"shared": 1}}
+	for _, name := range []string{"kept", "removed", "shared", "offline", "promoted"} {
+		m.online[name] = OnlinePlayer{Name: name}
+	}
+	m.users["kept"] = User{"kept", true, false, 1}
+	m.users["removed"] = User{"removed", true, true, 9}
+	m.users["shared"] = User{"shared", true, false, 1}
+	m.users["offline"] = User{"offline", true, false, 1}
+	m.users["promoted"] = User{"promoted", false, false, 0}
+	delete(m.online, "offline")
+
+	cfg := defaultConfig()
+	cfg.admins = "kept,new"
+	cfg.superAdmins = "promoted"
+	m.applyConfig(cfg)
+
+	tests := []struct {
+		name  string
+		level int
+		exist bool
+	}{
+		{"kept", 1, true},
+		{"removed", 0, true},
+		{"shared", 1, true},
+		{"offline", 0, false},
+		{"promoted", 9, true},
+		{"new", 1, true},
+	}
+	for _, test := range tests {
+		user, ok := m.users[test.name]
+		if ok != test.exist || user.level != test.level || user.isAdmin != (test.level > 0) {
+			t.Errorf("%s = %+v %v, want level %d exist %v", test.name, user, ok, test.level, test.exist)
+		}
+	}
+	sent := strings.Join(in.sent(), ";")
+	for _, cmd := range []string{"unadmin removed", "admin promoted"} {
+		if !inList(in.sent(), cmd) {
+			t.Errorf("%q not sent:%s", cmd, sent)
+		}
+	}
+	for _, cmd := range []string{"unadmin shared", "unadmin kept", "admin kept"} {
+		if inList(in.sent(), cmd) {
+			t.Errorf("%q sent:%s", cmd, sent)
+		}
+	}
+}
+
+func TestParseIniLines(t *testing.T) {
+	data := "; comment\n[server]\nname = test\n\nadmins=a,b\n[shedule]\nhourTask=x\n[server]\nnotice\nName=dup\nname=again\n[backup\nfoo=1\n"
+	ini := parseIni("test.ini", data)
+	values := []struct {
+		section, key, value string
+		line                int
+	}{
+		{"server", "name", "again", 11},
+		{"server", "admins", "a,b", 5},
+	}
+	for _, test := range values {
+		v, ok := ini.sections[test.section][test.key]
+		if !ok || v.value != test.value || v.line != test.line || v.file != "test.ini" {
+			t.Errorf("%s.%s = %+v, want %q on line %d", test.section, test.key, v, test.value, test.line)
+		}
+	}
+	errs := []struct {
+		line  int
+		fatal bool
+		msg   string
+	}{
+		{6, false, "unknown section [shedule]"},
+		{9, true, "expected key=value"},
+		{10, false, "unknown key Name in [server], did you mean name?"},
+		{11, false, "already set on line 3"},
+		{12, true, "missing ]"},
+		{13, true, "foo is not in a section"},
+	}
+	if len(ini.errs) != len(errs) {
+		t.Fatalf("got %d errors, want %d:%v", len(ini.errs), len(errs), ini.errs)
+	}
+	for i, want := range errs {
+		got := ini.errs[i]
+		if got.File != "test.ini" || got.Line != want.line || got.Fatal != want.fatal || !strings.Contains(got.Msg, want.msg) {
+			t.Errorf("error %d = %+v, want line %d fatal %v %q", i, got, want.line, want.fatal, want.msg)
+		}
+	}
+}
+
+func TestParseIniValues(t *testing.T) {
+	data := "# top\r\n[ server ]\r\n  name =  a = b  \r\nnotice=\r\n[backup]\r\nprefix=x;y\r\n[server:pvp]\r\nport=6000\r\n[schedule:pvp]\r\nhourTask=0 0 * * * *\r\n"
+	ini := parseIni("test.ini", data)
+	if len(ini.errs) != 0 {
+		t.Fatalf("errors:%v", ini.errs)
+	}
+	tests := []struct {
+		section, key, value string
+	}{
+		{"server", "name", "a = b"},
+		{"server", "notice", ""},
+		{"backup", "prefix", "x;y"},
+		{"server:pvp", "port", "6000"},
+		{"schedule:pvp", "hourTask", "0 0 * * * *"},
+	}
+	for _, test := range tests {
+		if v, ok := ini.sections[test.section][test.key]; !ok || v.value != test.value {
+			t.Errorf("%s.%s = %q %v, want %q", test.section, test.key, v.value, ok, test.value)
+		}
+	}
+	if len(ini.instances) != 1 || ini.instances[0] != "pvp" {
+		t.Errorf("instances = %v", ini.instances)
+	}
+}
+
+func TestReadConfigInstances(t *testing.T) {
+	defer func(file string) { configFile, configOverrides, overrideErrs = file, nil, nil }(configFile)
+	chdirTemp(t)
+	writeLocales(t, LOCALE_PATH, map[string]string{"en_US.json": "{}"})
+	os.Mkdir("pvp", 0777)
+	os.Mkdir("pve", 0777)
+	configFile = filepath.Join(t.TempDir(), "config.ini")
+	ioutil.WriteFile(configFile, []byte(`[server]
+admins=a
+notice=base
+language=en_US
+[server:pvp]
+dir=pvp
+port=6000
+admins=b
+notice=pvp
+[server:pve]
+dir=pve
+port=6001
+[schedule]
+hourTask=0 0 * * * ?
+[schedule:pve]
+hourTask=0 30 * * * ?
+`), 0666)
+	t.Setenv("MINDUSTRY_ADMIN_SERVER_NOTICE", "env")
+	t.Setenv("MINDUSTRY_ADMIN_SERVER_PVE_ADMINS", "c")
+	loadEnvOverrides()
+	configOverrides = append(configOverrides, configOverride{"server:pve", "notice", "set", "-set server:pve.notice"})
+	tests := []struct {
+		instance, admins, notice, hourTask string
+	}{
+		{"", "a", "env", "0 0 * * * ?"},
+		//an override of [server] wins over the file value of an instance
+		{"pvp", "b", "env", "0 0 * * * ?"},
+		//an override of the instance wins over one of [server]
+		{"pve", "c", "set", "0 30 * * * ?"},
+	}
+	for _, test := range tests {
+		cfg, errs := readConfig(configFile, test.instance)
+		if cfg == nil {
+			t.Fatalf("%s: %v", test.instance, errs)
+		}
+		hourTask := ""
+		for _, task := range cfg.schedule {
+			if task.Name == "hourTask" {
+				hourTask = task.Spec
+			}
+		}
+		if cfg.admins != test.admins || cfg.notice != test.notice || hourTask != test.hourTask || cfg.language != "en_US" {
+			t.Errorf("%q: admins %q, notice %q, hourTask %q, language %q", test.instance, cfg.admins, cfg.notice, hourTask, cfg.language)
+		}
+	}
+}
+
+func TestGetList(t *testing.T) {
+	tests := []struct {
+		value, want string
+		warned      bool
+	}{
+		{"", "", false},
+		{"a, b ,c", "a,b,c", false},
+		{"a,,b", "a,b", true},
+		{"a,", "a", true},
+	}
+	for _, test := range tests {
+		ini := parseIni("test.ini", "[server]\nadmins="+test.value+"\n")
+		value := ""
+		ini.getList("server", "admins", &value)
+		if value != test.want || (len(ini.errs) > 0) != test.warned {
+			t.Errorf("admins=%s: %q, errors %v", test.value, value, ini.errs)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const CONSOLE_HISTORY_LINES = 500
+const CONSOLE_SUBSCRIBER_BUFFER = 256
+
+// ConsoleHub fans the server's stdout out to web consoles. New subscribers
+// get the last lines first, a subscriber that can't keep up loses lines
+// instead of blocking the read loop.
+type ConsoleHub struct {
+	lock        sync.Mutex
+	history     []string
+	subscribers map[chan string]bool
+}
+
+func newConsoleHub() *ConsoleHub {
+	return &ConsoleHub{subscribers: make(map[chan string]bool)}
+}
+
+func (this *ConsoleHub) publish(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.history = append(this.history, line)
+	if len(this.history) > CONSOLE_HISTORY_LINES {
+		this.history = this.history[len(this.history)-CONSOLE_HISTORY_LINES:]
+	}
+	for c := range this.subscribers {
+		select {
+		case c <- line:
+		default:
+		}
+	}
+}
+
+func (this *ConsoleHub) subscribe() (chan string, []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	c := make(chan string, CONSOLE_SUBSCRIBER_BUFFER)
+	this.subscribers[c] = true
+	return c, append([]string{}, this.history...)
+}
+
+func (this *ConsoleHub) unsubscribe(c chan string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.subscribers, c)
+}
+
+// consoleHandler serves /console/stream (server-sent events) and
+// /console/cmd, both only for superAdmins.
+type consoleHandler struct {
+	mindustry *Mindustry
+}
+
+func (this *consoleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userName := webUser(r)
+	this.mindustry.lock.Lock()
+	isSuperAdmin := this.mindustry.users[userName].isSuperAdmin
+	this.mindustry.lock.Unlock()
+	if !isSuperAdmin {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/console/") {
+	case "stream":
+		this.handleStream(w, r)
+	case "cmd":
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		this.handleCmd(w, r, userName)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (this *consoleHandler) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	c, history := this.mindustry.console.subscribe()
+	defer this.mindustry.console.unsubscribe(c)
+	for _, line := range history {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case line := <-c:
+			fmt.Fprintf(w, "data: %s\n\n", line)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (this *consoleHandler) handleCmd(w http.ResponseWriter, r *http.Request, userName string) {
+	var req struct {
+		Cmd string `json:"cmd"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	inputCmd := strings.TrimSpace(req.Cmd)
+	if inputCmd == "" || strings.ContainsAny(inputCmd, "\r\n") {
+		http.Error(w, "invalid command", http.StatusBadRequest)
+		return
+	}
+	this.mindustry.lock.Lock()
+	in := this.mindustry.in
+	this.mindustry.lock.Unlock()
+	if in == nil {
+		this.mindustry.audit(AUDIT_HTTP, userName, inputCmd, cmdResult(errServerNotRunning))
+		http.Error(w, "server is not running", http.StatusServiceUnavailable)
+		return
+	}
+	httpLog.with(this.mindustry.id).infof("[console]%s:%s", userName, inputCmd)
+	this.mindustry.console.publish("> " + inputCmd)
+	this.mindustry.consoleCmd(in, userName, inputCmd, AUDIT_HTTP)
+	w.WriteHeader(http.StatusOK)
+}
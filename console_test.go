@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConsoleHub(t *testing.T) {
+	hub := newConsoleHub()
+	for i := 0; i < CONSOLE_HISTORY_LINES+10; i++ {
+		hub.publish(fmt.Sprintf("line %d\r\n", i))
+	}
+	slow, history := hub.subscribe()
+	if len(history) != CONSOLE_HISTORY_LINES || history[0] != "line 10" {
+		t.Fatalf("history has %d lines from %q", len(history), history[0])
+	}
+	fast, _ := hub.subscribe()
+	for i := 0; i < CONSOLE_SUBSCRIBER_BUFFER+10; i++ {
+		hub.publish("new")
+		<-fast
+	}
+	if len(slow) != CONSOLE_SUBSCRIBER_BUFFER {
+		t.Fatalf("slow subscriber has %d lines, want the buffer of %d", len(slow), CONSOLE_SUBSCRIBER_BUFFER)
+	}
+	hub.unsubscribe(fast)
+	hub.publish("gone")
+	if len(fast) != 0 {
+		t.Fatal("line sent after unsubscribe")
+	}
+}
+
+func consoleRequest(m *Mindustry, userName string, method string, path string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), webUserKey{}, userName))
+	w := httptest.NewRecorder()
+	(&consoleHandler{m}).ServeHTTP(w, r)
+	return w
+}
+
+func TestConsoleCmd(t *testing.T) {
+	in := &recordWriter{}
+	m := newTestMindustry(t, testIni, in)
+	tests := []struct {
+		userName, method, path, body string
+		status                       int
+		sent                         string
+	}{
+		{"admin", "POST", "/console/cmd", `{"cmd":"status"}`, 403, ""},
+		{"super", "POST", "/console/cmd", `{"cmd":" status "}`, 200, "status"},
+		{"super", "POST", "/console/cmd", `{"cmd":"say a\nexit"}`, 400, ""},
+		{"super", "POST", "/console/cmd", `{"cmd":""}`, 400, ""},
+		{"super", "GET", "/console/cmd", ``, 405, ""},
+		{"super", "GET", "/console/other", ``, 404, ""},
+	}
+	for _, test := range tests {
+		before := len(in.sent())
+		if w := consoleRequest(m, test.userName, test.method, test.path, test.body); w.Code != test.status {
+			t.Errorf("%s %s %s as %s = %d, want %d", test.method, test.path, test.body, test.userName, w.Code, test.status)
+		}
+		if sent := in.sent()[before:]; test.sent == "" && len(sent) > 0 || test.sent != "" && !inList(sent, test.sent) {
+			t.Errorf("%s %s sent %q, want %q", test.path, test.body, sent, test.sent)
+		}
+	}
+	if _, history := m.console.subscribe(); !inList(history, "> status") {
+		t.Errorf("command not shown in the console:%q", history)
+	}
+}
+
+func TestConsoleStream(t *testing.T) {
+	m := newTestMindustry(t, testIni, &recordWriter{})
+	m.console.publish("before")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), webUserKey{}, "super"))
+		(&consoleHandler{m}).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/console/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("content type %q", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+	if line := readEvent(); line != "data: before" {
+		t.Fatalf("history line %q", line)
+	}
+	//the handler subscribed before it wrote the history
+	m.console.publish("after")
+	if line := readEvent(); line != "data: after" {
+		t.Fatalf("live line %q", line)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CHAT_HISTORY_LINES = 100
+
+// statusMapR matches the map line of the server's status output, eg
+// "Playing on map Fortress / Wave 3".
+var statusMapR = regexp.MustCompile("(?i)playing on map (.+?) / wave (\\d+)")
+
+type ChatMsg struct {
+	Time     time.Time `json:"time"`
+	UserName string    `json:"userName"`
+	Message  string    `json:"message"`
+}
+
+// GameState is what the dashboard shows about the running game. The map and
+// mode follow the host/load commands sent to the server, the wave is updated
+// by every status query.
+type GameState struct {
+	Map        string    `json:"map"`
+	Mode       string    `json:"mode"`
+	Wave       int       `json:"wave"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+func (this *Mindustry) addChat(userName string, message string) {
+	this.logChat(CHAT_CHAT, userName, this.online[userName].Uuid, message)
+	this.chats = append(this.chats, ChatMsg{time.Now(), userName, message})
+	if len(this.chats) > CHAT_HISTORY_LINES {
+		this.chats = this.chats[len(this.chats)-CHAT_HISTORY_LINES:]
+	}
+}
+
+// trackGame is called for every command written to the server.
+func (this *Mindustry) trackGame(cmd string) {
+	temps := strings.Fields(cmd)
+	if len(temps) < 2 {
+		return
+	}
+	switch temps[0] {
+	case "host":
+		mode := "survival"
+		if len(temps) > 2 {
+			mode = temps[2]
+		}
+		this.game = GameState{Map: strings.Replace(temps[1], "_", " ", -1), Mode: mode, UpdateTime: time.Now()}
+	case "load":
+		this.game = GameState{UpdateTime: time.Now()}
+		if info, err := readMsav(slotFileName(this.savePath(), temps[1]), false); err == nil {
+			this.game.Map = info.Tags["mapname"]
+			this.game.Wave, _ = strconv.Atoi(info.Tags["wave"])
+		}
+	}
+}
+
+// trackStatus picks the map and wave out of a status output line.
+func (this *Mindustry) trackStatus(line string) {
+	if m := statusMapR.FindStringSubmatch(line); m != nil {
+		this.game.Map = strings.TrimSpace(m[1])
+		this.game.Wave, _ = strconv.Atoi(m[2])
+		this.game.UpdateTime = time.Now()
+	}
+}
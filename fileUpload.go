@@ -2,9 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,9 +13,18 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 const FILE_PATH = "./config/maps/"
+const MAX_FILE_NAME_LEN = 128
+const UPLOAD_FORM_OVERHEAD = 64 << 10
+
+var uploadExts = []string{".msav"}
+
+// maxUploadSize is set from maxUploadSize(KB) in config.ini, it changes on
+// reload so it is accessed atomically.
+var maxUploadSize int64 = 1 << 20
 
 type FileDesc struct {
 	Id   int    `json:"id"`
@@ -23,35 +33,73 @@ type FileDesc struct {
 	Path string `json:"path"`
 }
 
-func init() {
-	err := initFilePath(FILE_PATH)
-	if err != nil {
-		fmt.Println(err)
+// initFilePaths creates the directories of maps, saves and thumbnails when
+// the servers start, the -check-* and -print-config runs leave the disk alone.
+func initFilePaths() {
+	for _, filePath := range []string{FILE_PATH, SAVE_PATH, MAP_STORE_PATH, MAP_OBJECT_PATH, THUMB_PATH} {
+		if err := initFilePath(filePath); err != nil {
+			supervisorLog.errorf("%v", err)
+		}
 	}
 }
-func StartFileUpServer(port int) {
+
+// StartFileUpServer serves every instance, console, api, metrics and saves
+// take ?server=NAME to pick one.
+func StartFileUpServer(port int, servers *Servers) {
 	mux := http.NewServeMux()
 	fs := http.FileServer(http.Dir("map_manager"))
 	mux.Handle("/", fs)
+	webAuth := newWebAuth(servers)
+	for _, mindustry := range servers.list {
+		mindustry.lock.Lock()
+		mindustry.webAuth = webAuth
+		mindustry.lock.Unlock()
+	}
+	mux.Handle("/auth/", webAuth)
 	mh := http.HandlerFunc(handleRequest)
-	mux.Handle("/files/", mh)
+	mux.Handle("/files/", limitUpload(webAuth.protect(mh)))
+	mux.Handle("/console/", webAuth.protect(servers.route(func(m *Mindustry) http.Handler {
+		return &consoleHandler{m}
+	})))
+	mux.Handle(API_PREFIX, webAuth.protect(servers.route(func(m *Mindustry) http.Handler {
+		return &apiHandler{m}
+	})))
+	mux.Handle("/metrics", webAuth.protect(servers.route(func(m *Mindustry) http.Handler {
+		return &metricsHandler{m}
+	})))
+	mux.Handle("/trash/", webAuth.protect(http.HandlerFunc(handleTrash)))
+	mux.Handle("/thumbs/", webAuth.protect(http.HandlerFunc(handleThumb)))
+	mux.Handle("/saves/", limitUpload(webAuth.protect(servers.route(func(m *Mindustry) http.Handler {
+		return &saveHandler{m}
+	}))))
 	server := &http.Server{
 		Addr:    "0.0.0.0:" + strconv.Itoa(port),
 		Handler: mux,
 	}
 
-	fmt.Println("file up server listening on: http://0.0.0.0:" + strconv.Itoa(port))
+	httpLog.infof("file up server listening on: http://0.0.0.0:%d", port)
+	go updateAllThumbs()
 	go func() {
 		c := make(chan os.Signal)
 		signal.Notify(c, os.Interrupt, os.Kill)
 		s := <-c
 		server.Close()
-		fmt.Printf("file up server shutdownf%s", s)
+		httpLog.infof("file up server shutdown:%s", s)
 	}()
 	server.ListenAndServe()
 }
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/files"), "/"), "/")
+	if len(parts) == 2 {
+		name, err := sanitizeFileName(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		handleMapVersions(w, r, name, parts[1])
+		return
+	}
 	var err error
 	switch r.Method {
 	case "GET":
@@ -60,6 +108,9 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		err = handlePost(w, r)
 	case "DELETE":
 		err = handleDelete(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -68,14 +119,20 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleGet(w http.ResponseWriter, r *http.Request) (err error) {
-	fmt.Println("GET: " + r.URL.Path)
-	name := path.Base(r.URL.Path)
-	if strings.Contains(name, ".") {
-		fmt.Println("download: " + name)
+	httpLog.debugf("GET: %s", r.URL.Path)
+	if strings.Trim(strings.TrimPrefix(r.URL.Path, "/files"), "/") != "" {
+		name, err1 := sanitizeFileName(path.Base(r.URL.Path))
+		if err1 != nil {
+			http.Error(w, err1.Error(), http.StatusBadRequest)
+			return
+		}
+		httpLog.debugf("download: %s", name)
 		file := FILE_PATH + name
 		if exist, _ := exists(file); !exist {
 			http.NotFound(w, r)
+			return
 		}
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
 		http.ServeFile(w, r, file)
 		return
 	} else {
@@ -84,18 +141,18 @@ func handleGet(w http.ResponseWriter, r *http.Request) (err error) {
 			err = err1
 			return
 		}
+		defer _dirpath.Close()
 		_dir, err1 := _dirpath.Readdir(0)
 		if err1 != nil {
 			err = err1
 			return
 		}
-		files := make([]FileDesc, len(_dir))
-		for i, f := range _dir {
-			files[i].Id = i + 1
-			files[i].Name = f.Name()
-			files[i].Path = ""
-			files[i].Size = f.Size()
-
+		files := []FileDesc{}
+		for _, f := range _dir {
+			if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			files = append(files, FileDesc{Id: len(files) + 1, Name: f.Name(), Size: f.Size()})
 		}
 		output, err1 := json.MarshalIndent(&files, "", "\t\t")
 		if err1 != nil {
@@ -103,6 +160,7 @@ func handleGet(w http.ResponseWriter, r *http.Request) (err error) {
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Max-Upload-Size", strconv.FormatInt(atomic.LoadInt64(&maxUploadSize), 10))
 		w.Write(output)
 		return
 	}
@@ -110,47 +168,140 @@ func handleGet(w http.ResponseWriter, r *http.Request) (err error) {
 }
 
 func handlePost(w http.ResponseWriter, r *http.Request) (err error) {
-	fmt.Println("POST: " + r.URL.Path)
-	r.ParseMultipartForm(32 << 20)
-	file, handler, err := r.FormFile("newfile")
+	httpLog.debugf("POST: %s", r.URL.Path)
+	tmpFile, fileName, status, err := receiveUpload(w, r, FILE_PATH)
 	if err != nil {
-		fmt.Println(err)
-		return
+		http.Error(w, err.Error(), status)
+		return nil
 	}
-	defer file.Close()
-	fmt.Fprintf(w, "%v", handler.Header)
-	f, err := os.OpenFile(FILE_PATH+handler.Filename, os.O_WRONLY|os.O_CREATE, 0666)
+	name, err := sanitizeFileName(fileName)
 	if err != nil {
-		fmt.Println(err)
+		os.Remove(tmpFile)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if err = mapStore.commit(name, tmpFile, webUser(r)); err != nil {
+		os.Remove(tmpFile)
 		return
 	}
-	defer f.Close()
-	io.Copy(f, file)
+	go updateThumb(name)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s", name)
 	return
 }
 func handleDelete(w http.ResponseWriter, r *http.Request) (err error) {
-	fmt.Println("DELETE: " + r.URL.Path)
-	name := path.Base(r.URL.Path)
-	fmt.Println("DELETE: " + name)
-	err = os.Remove(FILE_PATH + name)
+	httpLog.debugf("DELETE: %s", r.URL.Path)
+	name, err := sanitizeFileName(path.Base(r.URL.Path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	httpLog.infof("DELETE: %s", name)
+	err = mapStore.remove(name, webUser(r))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return nil
+	}
 	if err != nil {
-		fmt.Println(err)
+		httpLog.warnf("%v", err)
+		return
 	}
-	w.WriteHeader(200)
+	os.Remove(thumbFileName(name))
+	w.WriteHeader(http.StatusOK)
 	return
 }
+
+// sanitizeFileName only lets plain .msav file names through: no directories,
+// no hidden files and no control characters.
+func sanitizeFileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if index := strings.LastIndexAny(name, "/\\"); index >= 0 {
+		name = name[index+1:]
+	}
+	if name == "" || strings.HasPrefix(name, ".") || len(name) > MAX_FILE_NAME_LEN {
+		return "", errors.New("invalid file name")
+	}
+	for _, c := range name {
+		if c < ' ' || c == 0x7f || strings.ContainsRune(":*?\"<>|", c) {
+			return "", errors.New("invalid file name")
+		}
+	}
+	if !checkExt(name) {
+		return "", errors.New("only " + strings.Join(uploadExts, ",") + " files are allowed")
+	}
+	return name, nil
+}
+
+// limitUpload caps the request body before anything parses the multipart
+// form, the part itself is checked against maxUploadSize in receiveUpload.
+func limitUpload(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			r.Body = http.MaxBytesReader(w, r.Body, atomic.LoadInt64(&maxUploadSize)+UPLOAD_FORM_OVERHEAD)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// receiveUpload stores the "newfile" part in a temp file inside dir and checks
+// that it really is a Mindustry save/map. The caller renames the temp file
+// into place, so a half written upload never replaces a good file.
+func receiveUpload(w http.ResponseWriter, r *http.Request, dir string) (tmpFile string, fileName string, status int, err error) {
+	if err = r.ParseMultipartForm(32 << 20); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return "", "", http.StatusRequestEntityTooLarge, errors.New("file too large")
+		}
+		return "", "", http.StatusBadRequest, err
+	}
+	file, handler, err := r.FormFile("newfile")
+	if err != nil {
+		return "", "", http.StatusBadRequest, err
+	}
+	defer file.Close()
+	maxSize := atomic.LoadInt64(&maxUploadSize)
+	if handler.Size > maxSize {
+		return "", "", http.StatusRequestEntityTooLarge, errors.New("file too large")
+	}
+	f, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return "", "", http.StatusInternalServerError, err
+	}
+	n, err := io.Copy(f, io.LimitReader(file, maxSize))
+	if err == nil {
+		err = f.Chmod(0666)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", http.StatusInternalServerError, err
+	}
+	if _, err = readMsav(f.Name(), false); err != nil {
+		os.Remove(f.Name())
+		return "", "", http.StatusUnsupportedMediaType, errors.New("not a mindustry map/save file")
+	}
+	if dir == FILE_PATH {
+		uploadMetrics.add("map", n)
+	} else {
+		uploadMetrics.add("save", n)
+	}
+	return f.Name(), handler.Filename, http.StatusOK, nil
+}
 func getCurrentDirectory() string {
 	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 	if err != nil {
-		log.Fatal(err)
+		httpLog.fatalf("%v", err)
 	}
 	return strings.Replace(dir, "\\", "/", -1)
 }
 func initFilePath(filePath string) (err error) {
-	if _, err = exists(filePath); err != nil {
+	exist, err := exists(filePath)
+	if err != nil || exist {
 		return
 	}
-	err = os.Mkdir(filePath, 0777)
+	err = os.MkdirAll(filePath, 0777)
 	return
 }
 func exists(path string) (bool, error) {
@@ -166,13 +317,11 @@ func exists(path string) (bool, error) {
 
 	return true, err
 }
-func check(name string) bool {
-	ext := []string{".mmap"}
-
-	for _, v := range ext {
-		if v == name {
-			return false
+func checkExt(name string) bool {
+	for _, v := range uploadExts {
+		if strings.EqualFold(path.Ext(name), v) {
+			return true
 		}
 	}
-	return true
+	return false
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+const JOB_HISTORY = 20
+
+const (
+	JOB_RUNNING  = "running"
+	JOB_DONE     = "done"
+	JOB_CANCELED = "canceled"
+	JOB_FAILED   = "failed"
+)
+
+// JobStep waits delay and then runs with the Mindustry lock held. Once a
+// final step ran the job can no longer be canceled, eg after the server was
+// stopped it has to be hosted again.
+type JobStep struct {
+	name  string
+	delay time.Duration
+	final bool
+	run   func(in io.WriteCloser)
+}
+
+// Job is a long running action like restarting the server with a new map. It
+// runs in its own goroutine so the output loop keeps reading meanwhile.
+type Job struct {
+	Id         int       `json:"id"`
+	Name       string    `json:"name"`
+	User       string    `json:"user"`
+	State      string    `json:"state"`
+	Step       int       `json:"step"`
+	Steps      int       `json:"steps"`
+	Progress   string    `json:"progress"`
+	Cancelable bool      `json:"cancelable"`
+	Error      string    `json:"error,omitempty"`
+	StartTime  time.Time `json:"startTime"`
+	EndTime    time.Time `json:"endTime"`
+	cancel     chan struct{}
+}
+
+// startJob runs with the Mindustry lock held, only one job runs at a time.
+func (this *Mindustry) startJob(in io.WriteCloser, userName string, name string, steps []JobStep) bool {
+	if this.job != nil {
+		this.say(in, "error.job_running", this.job.Name, this.job.Progress)
+		return false
+	}
+	this.jobSeq++
+	job := &Job{
+		Id:         this.jobSeq,
+		Name:       name,
+		User:       userName,
+		State:      JOB_RUNNING,
+		Steps:      len(steps),
+		Cancelable: true,
+		StartTime:  time.Now(),
+		cancel:     make(chan struct{}),
+	}
+	this.job = job
+	this.jobs = append(this.jobs, job)
+	if len(this.jobs) > JOB_HISTORY {
+		this.jobs = this.jobs[len(this.jobs)-JOB_HISTORY:]
+	}
+	commandsLog.with(this.id).infof("[job]%d %s started by %s", job.Id, name, userName)
+	go this.runJob(job, steps)
+	return true
+}
+
+func (this *Mindustry) runJob(job *Job, steps []JobStep) {
+	for i, step := range steps {
+		this.lock.Lock()
+		job.Step = i + 1
+		job.Progress = step.name
+		this.lock.Unlock()
+		if step.delay > 0 {
+			timer := time.NewTimer(step.delay)
+			select {
+			case <-job.cancel:
+				timer.Stop()
+				this.finishJob(job, JOB_CANCELED, "")
+				return
+			case <-timer.C:
+			}
+		}
+		this.lock.Lock()
+		if this.in == nil {
+			this.lock.Unlock()
+			this.finishJob(job, JOB_FAILED, errServerNotRunning.Error())
+			return
+		}
+		if step.final {
+			job.Cancelable = false
+		}
+		step.run(this.in)
+		this.lock.Unlock()
+	}
+	this.finishJob(job, JOB_DONE, "")
+}
+
+func (this *Mindustry) finishJob(job *Job, state string, errInfo string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	job.State = state
+	job.Error = errInfo
+	job.Cancelable = false
+	job.EndTime = time.Now()
+	if this.job == job {
+		this.job = nil
+	}
+	commandsLog.with(this.id).infof("[job]%d %s %s %s", job.Id, job.Name, state, errInfo)
+	if state == JOB_CANCELED && this.in != nil {
+		this.say(this.in, "info.job_canceled", job.Name)
+	}
+}
+
+// restartSteps announces a restart, stops the server and runs cmd, which
+// hosts or loads the next game.
+func (this *Mindustry) restartSteps(cmd string, first ...JobStep) []JobStep {
+	steps := append(first, JobStep{name: "announce", run: func(in io.WriteCloser) {
+		this.sayAll(in, "info.server_restart")
+	}})
+	return append(steps,
+		JobStep{name: "stop", delay: 5 * time.Second, final: true, run: func(in io.WriteCloser) {
+			this.execCmd(in, "stop")
+		}},
+		JobStep{name: cmd, delay: 5 * time.Second, run: func(in io.WriteCloser) {
+			this.execCmd(in, cmd)
+		}},
+	)
+}
+
+func (this *Mindustry) proc_cancel(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	if this.job == nil {
+		this.say(in, "error.job_none")
+		return false
+	}
+	if !this.job.Cancelable {
+		this.say(in, "error.job_not_cancelable", this.job.Name, this.job.Progress)
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	commandsLog.with(this.id).infof("[job]%d canceled by %s", this.job.Id, userName)
+	this.job.Cancelable = false
+	close(this.job.cancel)
+	return true
+}
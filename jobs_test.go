@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func newJobMindustry(in *recordWriter) *Mindustry {
+	return &Mindustry{in: in, i18n: &Locale{messages: map[string]string{}}, online: make(map[string]OnlinePlayer)}
+}
+
+// waitJob waits until the job left the running state and returns it.
+func waitJob(t *testing.T, m *Mindustry, job *Job) Job {
+	for i := 0; i < 200; i++ {
+		m.lock.Lock()
+		state := *job
+		m.lock.Unlock()
+		if state.State != JOB_RUNNING {
+			return state
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s still running", job.Name)
+	return Job{}
+}
+
+func sendStep(name string, delay time.Duration, final bool) JobStep {
+	return JobStep{name: name, delay: delay, final: final, run: func(in io.WriteCloser) {
+		in.Write([]byte(name + "\n"))
+	}}
+}
+
+func TestJobSteps(t *testing.T) {
+	in := &recordWriter{}
+	m := newJobMindustry(in)
+	m.lock.Lock()
+	started := m.startJob(in, "admin", "test", []JobStep{sendStep("a", 0, false), sendStep("b", 20*time.Millisecond, true)})
+	second := m.startJob(in, "admin", "other", nil)
+	job := m.job
+	m.lock.Unlock()
+	if !started || second {
+		t.Fatalf("started %v, second job started %v", started, second)
+	}
+	done := waitJob(t, m, job)
+	if done.State != JOB_DONE || done.Step != 2 || done.Cancelable || done.EndTime.IsZero() || m.job != nil {
+		t.Fatalf("job = %+v", done)
+	}
+	if sent := in.sent(); len(sent) != 3 || sent[1] != "a" || sent[2] != "b" {
+		t.Fatalf("sent %q", sent)
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []JobStep
+		wait     time.Duration
+		canceled bool
+		state    string
+		sent     []string
+	}{
+		{"during a delay", []JobStep{sendStep("announce", 0, false), sendStep("stop", time.Second, true)}, 0, true, JOB_CANCELED, []string{"announce"}},
+		{"after the final step", []JobStep{sendStep("stop", 0, true), sendStep("host", 100*time.Millisecond, false)}, 30 * time.Millisecond, false, JOB_DONE, []string{"stop", "host"}},
+	}
+	for _, test := range tests {
+		in := &recordWriter{}
+		m := newJobMindustry(in)
+		m.lock.Lock()
+		m.startJob(in, "admin", test.name, test.steps)
+		job := m.job
+		m.lock.Unlock()
+		time.Sleep(test.wait + 10*time.Millisecond)
+		m.lock.Lock()
+		canceled := m.proc_cancel(in, "admin", "cancel", false)
+		m.lock.Unlock()
+		if canceled != test.canceled {
+			t.Errorf("%s: cancel = %v, want %v", test.name, canceled, test.canceled)
+		}
+		if state := waitJob(t, m, job); state.State != test.state {
+			t.Errorf("%s: state %s, want %s", test.name, state.State, test.state)
+		}
+		sent := []string{}
+		for _, line := range in.sent() {
+			if line == "announce" || line == "stop" || line == "host" {
+				sent = append(sent, line)
+			}
+		}
+		if len(sent) != len(test.sent) || sent[len(sent)-1] != test.sent[len(test.sent)-1] {
+			t.Errorf("%s: ran %q, want %q", test.name, sent, test.sent)
+		}
+	}
+}
+
+func TestJobServerStopped(t *testing.T) {
+	in := &recordWriter{}
+	m := newJobMindustry(in)
+	m.lock.Lock()
+	m.startJob(in, "admin", "load 1", []JobStep{sendStep("stop", 20*time.Millisecond, true)})
+	job := m.job
+	//the lock is free while a job waits, the output loop keeps running
+	m.in = nil
+	m.lock.Unlock()
+	if state := waitJob(t, m, job); state.State != JOB_FAILED || state.Error != errServerNotRunning.Error() {
+		t.Fatalf("job = %+v", state)
+	}
+	if len(in.sent()) != 0 {
+		t.Fatalf("sent %q to a stopped server", in.sent())
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LOCALE_FALLBACK backs up every other language, a key missing there too is
+// shown as it is.
+const LOCALE_FALLBACK = "en_US"
+
+// Locale is the messages of one file of LOCALE_PATH, the nested objects of
+// the file are flattened to keys like "helps.maps".
+type Locale struct {
+	name     string
+	messages map[string]string
+	fallback *Locale
+}
+
+// Value looks the key up in the locale, then in en_US, and returns the key
+// itself if neither has it.
+func (this *Locale) Value(key string) string {
+	for locale := this; locale != nil; locale = locale.fallback {
+		if value, ok := locale.messages[key]; ok {
+			return value
+		}
+	}
+	return key
+}
+
+func flattenLocale(prefix string, data map[string]interface{}, messages map[string]string) {
+	for key, value := range data {
+		switch v := value.(type) {
+		case string:
+			messages[prefix+key] = v
+		case map[string]interface{}:
+			flattenLocale(prefix+key+".", v, messages)
+		}
+	}
+}
+
+func readLocale(fileName string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]interface{})
+	if err = json.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+	messages := make(map[string]string)
+	flattenLocale("", tree, messages)
+	return messages, nil
+}
+
+// Locales are the languages of a locale directory.
+type Locales map[string]*Locale
+
+// loadLocales reads every file of the directory, a file that can not be read
+// is left out and reported.
+func loadLocales(path string) (Locales, []error) {
+	locales, errs := make(Locales), []error{}
+	files, _ := filepath.Glob(filepath.Join(path, "*.json"))
+	for _, file := range files {
+		messages, err := readLocale(file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%v", file, err))
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		locales[name] = &Locale{name: name, messages: messages}
+	}
+	fallback := locales[LOCALE_FALLBACK]
+	for name, locale := range locales {
+		if name != LOCALE_FALLBACK {
+			locale.fallback = fallback
+		}
+	}
+	return locales, errs
+}
+
+func (this Locales) names() []string {
+	names := []string{}
+	for name := range this {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get returns the locale, en_US for a language there is no file for.
+func (this Locales) get(name string) *Locale {
+	if locale, ok := this[name]; ok {
+		return locale
+	}
+	if locale, ok := this[LOCALE_FALLBACK]; ok {
+		return locale
+	}
+	return &Locale{name: name, messages: make(map[string]string)}
+}
+
+// gaps lists the keys a locale takes from en_US and the helps of cmds that
+// even en_US does not have.
+func (this Locales) gaps(name string, cmds []string) []string {
+	locale := this.get(name)
+	gaps := []string{}
+	if fallback := locale.fallback; fallback != nil {
+		for key := range fallback.messages {
+			if _, ok := locale.messages[key]; !ok {
+				gaps = append(gaps, key)
+			}
+		}
+	}
+	for _, cmd := range cmds {
+		if locale.Value("helps."+cmd) == "helps."+cmd {
+			gaps = append(gaps, "helps."+cmd)
+		}
+	}
+	sort.Strings(gaps)
+	return gaps
+}
+
+// formatVerbR matches the verbs of a message, %% is not one.
+var formatVerbR = regexp.MustCompile("%[-+# 0]*[0-9]*(?:\\.[0-9]+)?[a-zA-Z%]")
+
+func formatVerbs(message string) []string {
+	verbs := []string{}
+	for _, verb := range formatVerbR.FindAllString(message, -1) {
+		if verb != "%%" {
+			verbs = append(verbs, verb[len(verb)-1:])
+		}
+	}
+	return verbs
+}
+
+// localeUse is a call passing a literal key to say, sayAll or tell.
+type localeUse struct {
+	pos  string
+	args int //values passed for the verbs, -1 if unknown
+}
+
+// scanLocaleUses finds the keys the .go files of dir pass to say, sayAll and
+// tell, and the commands given a handler in userCmdProcHandles. Tests are
+// left out, their keys are not in the locale files.
+func scanLocaleUses(dir string) (map[string][]localeUse, []string, error) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.go"))
+	uses, handlers := make(map[string][]localeUse), []string{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch node := n.(type) {
+			case *ast.CallExpr:
+				sel, ok := node.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				keyArg := map[string]int{"say": 1, "sayAll": 1, "tell": 2}
+				index, ok := keyArg[sel.Sel.Name]
+				if !ok || len(node.Args) <= index {
+					return true
+				}
+				if lit, ok := node.Args[index].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					key, _ := strconv.Unquote(lit.Value)
+					args := len(node.Args) - index - 1
+					if node.Ellipsis.IsValid() {
+						args = -1
+					}
+					uses[key] = append(uses[key], localeUse{fset.Position(lit.Pos()).String(), args})
+				}
+			case *ast.IndexExpr:
+				sel, ok := node.X.(*ast.SelectorExpr)
+				if !ok || sel.Sel.Name != "userCmdProcHandles" {
+					return true
+				}
+				if lit, ok := node.Index.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					cmd, _ := strconv.Unquote(lit.Value)
+					if cmd != "directCmd" && !inList(handlers, cmd) {
+						handlers = append(handlers, cmd)
+					}
+				}
+			}
+			return true
+		})
+	}
+	return uses, handlers, nil
+}
+
+// checkI18n compares the files of LOCALE_PATH with the keys the source in
+// srcDir uses and the commands of config.ini, for -check-i18n. Without the
+// source only the files and the commands are compared. It returns false if a
+// key is missing or its verbs do not match.
+func checkI18n(srcDir string) bool {
+	ok := true
+	report := func(fatal bool, format string, v ...interface{}) {
+		level := "warning"
+		if fatal {
+			level, ok = "error", false
+		}
+		fmt.Printf("%s: %s\n", level, fmt.Sprintf(format, v...))
+	}
+	locales, errs := loadLocales(LOCALE_PATH)
+	for _, err := range errs {
+		report(true, "%v", err)
+	}
+	fallback, found := locales[LOCALE_FALLBACK]
+	if !found {
+		report(true, "%s%s.json not found", LOCALE_PATH, LOCALE_FALLBACK)
+		return false
+	}
+	uses, handlers, err := scanLocaleUses(srcDir)
+	if err != nil {
+		report(true, "%v", err)
+	}
+	if len(uses) == 0 {
+		report(false, "no source in %s, the keys used in code are not checked", srcDir)
+	}
+	cmds := handlers
+	if cfg, _ := readConfig(configFile, ""); cfg != nil {
+		for _, list := range []string{cfg.normCmds, cfg.adminCmds, cfg.superAdminCmds} {
+			for _, cmd := range splitList(list) {
+				if !inList(cmds, cmd) {
+					cmds = append(cmds, cmd)
+				}
+			}
+		}
+	}
+	sort.Strings(cmds)
+
+	names := locales.names()
+	keys := []string{}
+	for key := range uses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, name := range names {
+		locale := locales[name]
+		for _, key := range keys {
+			if _, has := locale.messages[key]; !has {
+				report(true, "%s: %s is missing, used at %s", name, key, uses[key][0].pos)
+			}
+		}
+		for _, cmd := range cmds {
+			if _, has := locale.messages["helps."+cmd]; !has {
+				report(true, "%s: helps.%s is missing, \\help %s shows nothing", name, cmd, cmd)
+			}
+		}
+		messageKeys := []string{}
+		for key := range locale.messages {
+			messageKeys = append(messageKeys, key)
+		}
+		sort.Strings(messageKeys)
+		for _, key := range messageKeys {
+			message := locale.messages[key]
+			if _, has := fallback.messages[key]; !has && name != LOCALE_FALLBACK {
+				report(false, "%s: %s is not in %s", name, key, LOCALE_FALLBACK)
+			} else if _, used := uses[key]; !used && len(uses) > 0 && !strings.HasPrefix(key, "helps.") {
+				report(false, "%s: %s is not used", name, key)
+			}
+			verbs := strings.Join(formatVerbs(message), "")
+			if want := strings.Join(formatVerbs(fallback.messages[key]), ""); name != LOCALE_FALLBACK && fallback.messages[key] != "" && verbs != want {
+				report(true, "%s: %s has verbs %q, %s has %q", name, key, verbs, LOCALE_FALLBACK, want)
+			}
+			for _, use := range uses[key] {
+				if use.args >= 0 && use.args != len(formatVerbs(message)) {
+					report(true, "%s: %s has %d verbs, %s passes %d", name, key, len(formatVerbs(message)), use.pos, use.args)
+				}
+			}
+		}
+	}
+	if ok {
+		fmt.Printf("%s ok\n", LOCALE_PATH)
+	}
+	return ok
+}
+
+// warnLocaleGaps logs at startup what the server language takes from en_US
+// and the helps nobody has.
+func (this *Mindustry) warnLocaleGaps(language string) {
+	cmds := []string{}
+	for cmd := range this.cmds {
+		cmds = append(cmds, cmd)
+	}
+	if gaps := this.locales.gaps(language, cmds); len(gaps) > 0 {
+		supervisorLog.with(this.id).warnf("[i18n]%s misses %d keys, %s or the key is shown:%s", language, len(gaps), LOCALE_FALLBACK, strings.Join(gaps, ","))
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeLocales(t *testing.T, dir string, files map[string]string) {
+	os.MkdirAll(dir, 0777)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocaleFallback(t *testing.T) {
+	dir := t.TempDir()
+	writeLocales(t, dir, map[string]string{
+		"en_US.json":  `{"info":{"saved":"saved %s","gone":"gone"},"helps":{"maps":"list maps"}}`,
+		"zh_CN.json":  `{"info":{"saved":"已保存 %s"}}`,
+		"broken.json": `{"info":`,
+	})
+	locales, errs := loadLocales(dir)
+	if len(errs) != 1 || !reflect.DeepEqual(locales.names(), []string{"en_US", "zh_CN"}) {
+		t.Fatalf("locales %v, errors %v", locales.names(), errs)
+	}
+	tests := []struct {
+		locale, key, want string
+	}{
+		{"zh_CN", "info.saved", "已保存 %s"},
+		{"zh_CN", "info.gone", "gone"},
+		{"zh_CN", "helps.maps", "list maps"},
+		{"zh_CN", "info.unknown", "info.unknown"},
+		{"en_US", "info.saved", "saved %s"},
+		{"en_US", "info.unknown", "info.unknown"},
+		{"ru_RU", "info.gone", "gone"},
+	}
+	for _, test := range tests {
+		if got := locales.get(test.locale).Value(test.key); got != test.want {
+			t.Errorf("%s %s = %q, want %q", test.locale, test.key, got, test.want)
+		}
+	}
+	if gaps := locales.gaps("zh_CN", []string{"maps", "host"}); !reflect.DeepEqual(gaps, []string{"helps.host", "helps.maps", "info.gone"}) {
+		t.Errorf("gaps = %v", gaps)
+	}
+	if gaps := locales.gaps("en_US", []string{"maps"}); len(gaps) != 0 {
+		t.Errorf("en_US gaps = %v", gaps)
+	}
+	if value := (Locales{}).get("zh_CN").Value("info.saved"); value != "info.saved" {
+		t.Errorf("no locales: %q", value)
+	}
+}
+
+func TestFormatVerbs(t *testing.T) {
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"saved %s at %d%%", []string{"s", "d"}},
+		{"%-10s|%5.2f|%v", []string{"s", "f", "v"}},
+		{"100%%", []string{}},
+	}
+	for _, test := range tests {
+		if got := formatVerbs(test.message); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("formatVerbs(%q) = %v, want %v", test.message, got, test.want)
+		}
+	}
+}
+
+const localeSrc = `package main
+
+func (this *Mindustry) proc_test(in io.WriteCloser, userName string) {
+	this.say(in, "info.saved", "map")
+	this.tell(in, userName, "info.gone")
+	this.say(in, "info.missing")
+	this.say(in, key)
+	this.sayAll(in, "info.saved", args...)
+	this.userCmdProcHandles["test"] = this.proc_test
+	this.userCmdProcHandles["directCmd"] = this.proc_test
+}
+`
+
+func TestScanLocaleUses(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "test.go"), []byte(localeSrc), 0666)
+	ioutil.WriteFile(filepath.Join(dir, "test_test.go"), []byte("package main\n\nfunc f() { m.say(in, \"info.test\") }\n"), 0666)
+	uses, handlers, err := scanLocaleUses(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := map[string][]int{}
+	for key, list := range uses {
+		for _, use := range list {
+			args[key] = append(args[key], use.args)
+		}
+	}
+	want := map[string][]int{"info.saved": {1, -1}, "info.gone": {0}, "info.missing": {0}}
+	if !reflect.DeepEqual(args, want) || !reflect.DeepEqual(handlers, []string{"test"}) {
+		t.Fatalf("uses %v, handlers %v", args, handlers)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "bad.go"), []byte("package"), 0666)
+	if _, _, err := scanLocaleUses(dir); err == nil {
+		t.Fatal("no error for a file that does not parse")
+	}
+}
+
+func TestCheckI18n(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		ok    bool
+	}{
+		{"key used in code", map[string]string{
+			"en_US.json": `{"info":{"saved":"saved %s","gone":"gone"},"helps":{"test":"test"}}`,
+			"zh_CN.json": `{"info":{"saved":"已保存 %s","gone":"没了"},"helps":{"test":"测试"}}`,
+		}, false},
+		{"key of en_US", map[string]string{
+			"en_US.json": `{"info":{"saved":"saved %s","gone":"gone","missing":"missing"},"helps":{"test":"test"}}`,
+			"zh_CN.json": `{"info":{"saved":"已保存 %s","missing":"缺"},"helps":{"test":"测试"}}`,
+		}, false},
+		{"verbs differ", map[string]string{
+			"en_US.json": `{"info":{"saved":"saved %s","gone":"gone","missing":"missing"},"helps":{"test":"test"}}`,
+			"zh_CN.json": `{"info":{"saved":"已保存 %d","gone":"没了","missing":"缺"},"helps":{"test":"测试"}}`,
+		}, false},
+		{"no helps", map[string]string{
+			"en_US.json": `{"info":{"saved":"saved %s","gone":"gone","missing":"missing"}}`,
+		}, false},
+		{"all keys", map[string]string{
+			"en_US.json": `{"info":{"saved":"saved %s","gone":"gone","missing":"missing"},"helps":{"test":"test"}}`,
+			"zh_CN.json": `{"info":{"saved":"已保存 %s","gone":"没了","missing":"缺"},"helps":{"test":"测试"}}`,
+		}, true},
+		{"no en_US", map[string]string{
+			"zh_CN.json": `{}`,
+		}, false},
+	}
+	oldFile := configFile
+	defer func() { configFile = oldFile }()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chdirTemp(t)
+			configFile = "missing.ini"
+			writeLocales(t, LOCALE_PATH, test.files)
+			os.Mkdir("src", 0777)
+			ioutil.WriteFile("src/test.go", []byte(localeSrc), 0666)
+			if ok := checkI18n("src"); ok != test.ok {
+				t.Errorf("checkI18n = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
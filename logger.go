@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LOG_FILE is the log of the wrapper, it is rotated when it reaches
+// LOG_MAX_SIZE and the last LOG_KEEP_FILES are kept as .1, .2 ...
+const LOG_FILE = "./logs/mindustry_admin.log"
+const LOG_MAX_SIZE = 10 << 20
+const LOG_KEEP_FILES = 5
+
+const (
+	LOG_DEBUG = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// the components a message comes from
+const (
+	LOG_SUPERVISOR = "supervisor" //servers, config, cluster and the files of the wrapper
+	LOG_PARSER     = "parser"     //the output of the servers
+	LOG_COMMANDS   = "commands"   //commands of players, admins and the console
+	LOG_HTTP       = "http"       //map manager, web console and api
+	LOG_CRON       = "cron"       //scheduled tasks
+)
+
+var (
+	supervisorLog = Logger{component: LOG_SUPERVISOR}
+	parserLog     = Logger{component: LOG_PARSER}
+	commandsLog   = Logger{component: LOG_COMMANDS}
+	httpLog       = Logger{component: LOG_HTTP}
+	cronLog       = Logger{component: LOG_CRON}
+)
+
+type logEntry struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Component string    `json:"component"`
+	Server    string    `json:"server,omitempty"`
+	Msg       string    `json:"msg"`
+}
+
+// LogOutput writes every message to stdout and, once a file is set, to the
+// file. level and json are set by setupLog before the servers start.
+type LogOutput struct {
+	lock  sync.Mutex
+	level int
+	json  bool
+	file  string
+	out   *os.File
+	size  int64
+}
+
+var logOutput = &LogOutput{level: LOG_INFO}
+
+func parseLogLevel(name string) (int, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("log level %q is not one of %s", name, strings.Join(logLevelNames, ","))
+}
+
+// setupLog applies -log-level and -log-format and starts writing file, the
+// standard log is taken over for what libraries like net/http report.
+func setupLog(level string, format string, file string) error {
+	logLevel, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("log format %q is not text or json", format)
+	}
+	logOutput.lock.Lock()
+	logOutput.level, logOutput.json, logOutput.file = logLevel, format == "json", file
+	logOutput.lock.Unlock()
+	log.SetFlags(0)
+	log.SetOutput(logWriter{httpLog, LOG_WARN})
+	return nil
+}
+
+func (this *LogOutput) format(entry logEntry) []byte {
+	if this.json {
+		data, _ := json.Marshal(entry)
+		return append(data, '\n')
+	}
+	line := entry.Time.Format("2006/01/02 15:04:05") + " " + strings.ToUpper(entry.Level) + " " + entry.Component
+	if entry.Server != "" {
+		line += " [" + entry.Server + "]"
+	}
+	return []byte(line + " " + entry.Msg + "\n")
+}
+
+func (this *LogOutput) write(level int, component string, server string, msg string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	line := this.format(logEntry{time.Now(), logLevelNames[level], component, server, strings.TrimRight(msg, "\r\n")})
+	os.Stdout.Write(line)
+	if this.file == "" {
+		return
+	}
+	if this.out == nil {
+		if err := this.open(); err != nil {
+			fmt.Fprintf(os.Stderr, "log file %s fail:%v\n", this.file, err)
+			this.file = ""
+			return
+		}
+	}
+	n, _ := this.out.Write(line)
+	this.size += int64(n)
+	if this.size >= LOG_MAX_SIZE {
+		this.rotate()
+	}
+}
+
+func (this *LogOutput) open() error {
+	if err := os.MkdirAll(filepath.Dir(this.file), 0777); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(this.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	this.out, this.size = out, 0
+	if info, err := out.Stat(); err == nil {
+		this.size = info.Size()
+	}
+	return nil
+}
+
+// rotate moves the file to .1 and the older ones one further, the next
+// message opens a new file.
+func (this *LogOutput) rotate() {
+	this.out.Close()
+	this.out = nil
+	os.Remove(fmt.Sprintf("%s.%d", this.file, LOG_KEEP_FILES))
+	for i := LOG_KEEP_FILES - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", this.file, i), fmt.Sprintf("%s.%d", this.file, i+1))
+	}
+	os.Rename(this.file, this.file+".1")
+}
+
+// Logger writes the messages of a component, with the server they are about
+// when there are several.
+type Logger struct {
+	component string
+	server    string
+}
+
+func (this Logger) with(server string) Logger {
+	this.server = server
+	return this
+}
+
+func (this Logger) logf(level int, format string, v ...interface{}) {
+	if level < logOutput.level {
+		return
+	}
+	logOutput.write(level, this.component, this.server, fmt.Sprintf(format, v...))
+}
+
+func (this Logger) debugf(format string, v ...interface{}) {
+	this.logf(LOG_DEBUG, format, v...)
+}
+
+func (this Logger) infof(format string, v ...interface{}) {
+	this.logf(LOG_INFO, format, v...)
+}
+
+func (this Logger) warnf(format string, v ...interface{}) {
+	this.logf(LOG_WARN, format, v...)
+}
+
+func (this Logger) errorf(format string, v ...interface{}) {
+	this.logf(LOG_ERROR, format, v...)
+}
+
+// fatalf writes an error and exits.
+func (this Logger) fatalf(format string, v ...interface{}) {
+	this.logf(LOG_ERROR, format, v...)
+	os.Exit(1)
+}
+
+// logWriter is the output of the standard log.
+type logWriter struct {
+	logger Logger
+	level  int
+}
+
+func (this logWriter) Write(p []byte) (int, error) {
+	this.logger.logf(this.level, "%s", p)
+	return len(p), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int
+		ok    bool
+	}{
+		{"debug", LOG_DEBUG, true},
+		{"INFO", LOG_INFO, true},
+		{"Warn", LOG_WARN, true},
+		{"error", LOG_ERROR, true},
+		{"trace", 0, false},
+		{"", 0, false},
+	}
+	for _, test := range tests {
+		level, err := parseLogLevel(test.name)
+		if level != test.level || (err == nil) != test.ok {
+			t.Errorf("parseLogLevel(%q) = %d, %v", test.name, level, err)
+		}
+	}
+}
+
+func TestLogFormat(t *testing.T) {
+	entry := logEntry{time.Date(2026, 3, 1, 10, 4, 5, 0, time.Local), "warn", LOG_CRON, "pvp", "backup fail"}
+	text := string((&LogOutput{}).format(entry))
+	if text != "2026/03/01 10:04:05 WARN cron [pvp] backup fail\n" {
+		t.Errorf("text = %q", text)
+	}
+	entry.Server = ""
+	if text := string((&LogOutput{}).format(entry)); text != "2026/03/01 10:04:05 WARN cron backup fail\n" {
+		t.Errorf("text without server = %q", text)
+	}
+	decoded := logEntry{}
+	if err := json.Unmarshal((&LogOutput{json: true}).format(entry), &decoded); err != nil || decoded.Msg != entry.Msg ||
+		decoded.Level != "warn" || decoded.Component != LOG_CRON || !decoded.Time.Equal(entry.Time) {
+		t.Errorf("json = %+v, %v", decoded, err)
+	}
+}
+
+// testLogOutput writes the log to a temp file until the test ends.
+func testLogOutput(t *testing.T, level int, json bool) string {
+	file := filepath.Join(t.TempDir(), "logs", "test.log")
+	old := logOutput
+	logOutput = &LogOutput{level: level, json: json, file: file}
+	t.Cleanup(func() {
+		if logOutput.out != nil {
+			logOutput.out.Close()
+		}
+		logOutput = old
+	})
+	return file
+}
+
+func TestLogLevel(t *testing.T) {
+	file := testLogOutput(t, LOG_WARN, true)
+	supervisorLog.debugf("debug")
+	supervisorLog.with("pvp").infof("info")
+	supervisorLog.with("pvp").warnf("warn %d", 1)
+	httpLog.errorf("error\n")
+	data, _ := ioutil.ReadFile(file)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("log:\n%s", data)
+	}
+	want := []logEntry{{Level: "warn", Component: LOG_SUPERVISOR, Server: "pvp", Msg: "warn 1"}, {Level: "error", Component: LOG_HTTP, Msg: "error"}}
+	for i, line := range lines {
+		entry := logEntry{}
+		json.Unmarshal([]byte(line), &entry)
+		entry.Time = time.Time{}
+		if entry != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+}
+
+func TestLogRotate(t *testing.T) {
+	file := testLogOutput(t, LOG_INFO, false)
+	for i := 1; i <= LOG_KEEP_FILES+2; i++ {
+		cronLog.infof("line %d", i)
+		logOutput.size = LOG_MAX_SIZE
+		cronLog.infof("last of file %d", i)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("%s still there after rotating: %v", file, err)
+	}
+	for i := 1; i <= LOG_KEEP_FILES; i++ {
+		data, err := ioutil.ReadFile(fmt.Sprintf("%s.%d", file, i))
+		if want := fmt.Sprintf("last of file %d\n", LOG_KEEP_FILES+3-i); err != nil || !strings.HasSuffix(string(data), want) {
+			t.Errorf("%s.%d = %q, %v, want it to end with %q", file, i, data, err, want)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", file, LOG_KEEP_FILES+1)); !os.IsNotExist(err) {
+		t.Errorf("more than %d files kept", LOG_KEEP_FILES)
+	}
+	cronLog.infof("new file")
+	if data, _ := ioutil.ReadFile(file); !strings.HasSuffix(string(data), " INFO cron new file\n") {
+		t.Errorf("new file = %q", data)
+	}
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"math/rand"
 	"os"
 	"os/exec"
@@ -14,10 +14,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/kortemy/lingo"
-	"github.com/larspensjo/config"
 	"github.com/robfig/cron"
 )
 
@@ -45,7 +45,14 @@ type Cmd struct {
 	isVote bool
 }
 
+// Mindustry is shared by the stdout read loop, the stdin loop, cron tasks,
+// timers and the http handlers. Each of them takes lock before touching the
+// state, the proc_ handlers and the methods they call run with it held.
 type Mindustry struct {
+	lock               sync.Mutex
+	id                 string //instance name, "" for a single server
+	dir                string //working directory of the server, "" is the current one
+	servers            *Servers
 	name               string
 	admins             []string
 	cfgAdmin           string
@@ -65,133 +72,53 @@ type Mindustry struct {
 	cmdFailReason      string
 	currProcCmd        string
 	notice             string //cron task auto notice msg
-	playCnt            int
+	whisperCmd         string //server command sending a private message
+	replyTo            string //player whose command is running, answers given later use tell
 	serverIsStart      bool
 	serverIsRun        bool
 	maps               []string
+	online             map[string]OnlinePlayer
+	leaveTime          map[string]time.Time //of players that left since the last status answer
+	votetickCmd        string
+	startTime          time.Time
+	jvmStartTime       time.Time
+	restarts           int
+	crashes            int
+	restartRequested   bool
+	game               GameState
+	chats              []ChatMsg
 	userCmdProcHandles map[string]UserCmdProcHandle
-	l                  *lingo.L
-	i18n               lingo.T
+	job                *Job //running job
+	jobs               []*Job
+	jobSeq             int
+	executor           *CmdExecutor
+	in                 io.WriteCloser //stdin of the running server
+	webAuth            *WebAuth
+	apiTokens          map[string]string //sha256 of token -> admin name
+	bansSent           map[string]bool   //shared bans sent to the server, false for an unban
+	sharedRoles        map[string]int    //admins of the cluster -> level
+	config             *Config
+	schedule           []ScheduleTask
+	cron               *cron.Cron
+	console            *ConsoleHub
+	metrics            *Metrics
+	backup             *Backup
+	locales            Locales
+	i18n               *Locale //language of config.ini
 }
 
-func (this *Mindustry) loadConfig() {
-	this.l = lingo.New("en_US", "./locale")
-	cfg, err := config.ReadDefault("config.ini")
-	if err != nil {
-		log.Println("[ini]not find config.ini,use default config")
-		return
-	}
-	if cfg.HasSection("server") {
-		_, err := cfg.SectionOptions("server")
-		if err == nil {
-			optionValue := ""
-			optionValue, err = cfg.String("server", "admins")
-			if err == nil {
-				optionValue := strings.TrimSpace(optionValue)
-				admins := strings.Split(optionValue, ",")
-				this.cfgAdmin = optionValue
-				log.Printf("[ini]found admins:%v\n", admins)
-				for _, admin := range admins {
-					this.addUser(admin)
-					this.addAdmin(admin)
-				}
-			}
-			optionValue, err = cfg.String("server", "superAdmins")
-			if err == nil {
-				optionValue := strings.TrimSpace(optionValue)
-				supAdmins := strings.Split(optionValue, ",")
-				this.cfgSuperAdmin = optionValue
-				log.Printf("[ini]found supAdmins:%v\n", supAdmins)
-				for _, supAdmin := range supAdmins {
-					this.addUser(supAdmin)
-					this.addSuperAdmin(supAdmin)
-				}
-			}
-			optionValue, err = cfg.String("server", "superAdminCmds")
-			if err == nil {
-				optionValue := strings.TrimSpace(optionValue)
-				cmds := strings.Split(optionValue, ",")
-				this.cfgSuperAdminCmds = optionValue
-				log.Printf("[ini]found superAdminCmds:%v\n", cmds)
-				for _, cmd := range cmds {
-					this.cmds[cmd] = Cmd{cmd, 9, false}
-				}
-			}
-
-			optionValue, err = cfg.String("server", "adminCmds")
-			if err == nil {
-				optionValue := strings.TrimSpace(optionValue)
-				cmds := strings.Split(optionValue, ",")
-				log.Printf("[ini]found adminCmds:%v\n", cmds)
-				this.cfgAdminCmds = optionValue
-				for _, cmd := range cmds {
-					this.cmds[cmd] = Cmd{cmd, 1, false}
-				}
-			}
-			optionValue, err = cfg.String("server", "normCmds")
-			if err == nil {
-				optionValue := strings.TrimSpace(optionValue)
-				cmds := strings.Split(optionValue, ",")
-				log.Printf("[ini]found normCmds:%v\n", cmds)
-				this.cfgNormCmds = optionValue
-				for _, cmd := range cmds {
-					this.cmds[cmd] = Cmd{cmd, 0, false}
-				}
-			}
-			optionValue, err = cfg.String("server", "votetickCmds")
-			if err == nil {
-				optionValue := strings.TrimSpace(optionValue)
-				cmds := strings.Split(optionValue, ",")
-				log.Printf("[ini]found votetickCmds:%v\n", cmds)
-				this.cfgVoteCmds = optionValue
-				for _, cmd := range cmds {
-					if c, ok := this.cmds[cmd]; ok {
-						c.isVote = true
-						this.cmds[cmd] = c
-					} else {
-						log.Printf("[ini]votetick not found cmd:%s\n", cmd)
-					}
-				}
-			}
-
-			optionValue, err = cfg.String("server", "name")
-			if err == nil {
-				name := strings.TrimSpace(optionValue)
-				this.name = name
-			}
-			optionValue, err = cfg.String("server", "jarPath")
-			if err == nil {
-				jarPath := strings.TrimSpace(optionValue)
-				this.jarPath = jarPath
-			}
-			optionValue, err = cfg.String("server", "notice")
-			if err == nil {
-				notice := strings.TrimSpace(optionValue)
-				this.notice = notice
-			}
-
-			optionValue, err = cfg.String("server", "language")
-			if err == nil {
-				languageCfg := strings.TrimSpace(optionValue)
-				if languageCfg != "" {
-					log.Printf("[ini]lanage cfg:%s\n", languageCfg)
-					this.i18n = this.l.TranslationsForLocale(languageCfg)
-				} else {
-					this.i18n = this.l.TranslationsForLocale("en_US")
-					log.Printf("[ini]lanage cfg invalid,use english\n")
-				}
-			} else {
-				this.i18n = this.l.TranslationsForLocale("en_US")
-				log.Printf("[ini]lanage cfg invalid,use english\n")
-			}
-		}
-	}
-
-}
 func (this *Mindustry) init() {
 	this.serverOutR, _ = regexp.Compile(".*(\\[INFO\\]|\\[ERR\\])(.*)")
 	this.users = make(map[string]User)
 	this.votetickUsers = make(map[string]int)
+	this.online = make(map[string]OnlinePlayer)
+	this.leaveTime = make(map[string]time.Time)
+	this.apiTokens = make(map[string]string)
+	this.bansSent = make(map[string]bool)
+	this.sharedRoles = make(map[string]int)
+	this.console = newConsoleHub()
+	this.metrics = newMetrics()
+	this.startTime = time.Now()
 	this.cmds = make(map[string]Cmd)
 	this.cmdHelps = make(map[string]string)
 	this.userCmdProcHandles = make(map[string]UserCmdProcHandle)
@@ -216,12 +143,33 @@ func (this *Mindustry) init() {
 	this.userCmdProcHandles["showAdmin"] = this.proc_showAdmin
 	this.userCmdProcHandles["show"] = this.proc_show
 	this.userCmdProcHandles["votetick"] = this.proc_votetick
+	this.userCmdProcHandles["backups"] = this.proc_backups
+	this.userCmdProcHandles["restore"] = this.proc_restore
+	this.userCmdProcHandles["weblogin"] = this.proc_weblogin
+	this.userCmdProcHandles["restart"] = this.proc_restart
+	this.userCmdProcHandles["cancel"] = this.proc_cancel
+	this.userCmdProcHandles["reload"] = this.proc_reload
+	this.userCmdProcHandles["unadmin"] = this.proc_unadmin
+	this.userCmdProcHandles["config"] = this.proc_config
+	this.userCmdProcHandles["servers"] = this.proc_servers
+	this.userCmdProcHandles["announce"] = this.proc_announce
+	this.userCmdProcHandles["ban"] = this.proc_ban
+	this.userCmdProcHandles["unban"] = this.proc_unban
+	this.userCmdProcHandles["join"] = this.proc_join
+	this.userCmdProcHandles["lang"] = this.proc_lang
+	this.userCmdProcHandles["seen"] = this.proc_seen
+	this.userCmdProcHandles["lastsaid"] = this.proc_lastsaid
+	this.userCmdProcHandles["audit"] = this.proc_audit
 
 }
 
 func (this *Mindustry) execCommand(commandName string, params []string) error {
 	cmd := exec.Command(commandName, params...)
-	fmt.Println(cmd.Args)
+	if this.dir != "" {
+		cmd.Dir = this.dir
+		this.prepareDir()
+	}
+	supervisorLog.with(this.id).infof("start %v", cmd.Args)
 	stdout, outErr := cmd.StdoutPipe()
 	stdin, inErr := cmd.StdinPipe()
 	if outErr != nil {
@@ -232,44 +180,21 @@ func (this *Mindustry) execCommand(commandName string, params []string) error {
 		return inErr
 	}
 	cmd.Start()
+	this.lock.Lock()
+	this.in = stdin
+	this.executor = newCmdExecutor(stdin)
+	this.jvmStartTime = time.Now()
+	this.startSchedule(stdin)
+	this.lock.Unlock()
 	go func(cmd *exec.Cmd) {
 		c := make(chan os.Signal)
 		signal.Notify(c, os.Interrupt, os.Kill)
 		s := <-c
 		if cmd.Process != nil {
-			log.Printf("sub process exit:%s", s)
+			supervisorLog.with(this.id).infof("sub process exit:%s", s)
 			cmd.Process.Kill()
 		}
 	}(cmd)
-	c := cron.New()
-	spec := "0 0 * * * ?"
-	c.AddFunc(spec, func() {
-		this.hourTask(stdin)
-	})
-	spec = "0 5/10 * * * ?"
-	c.AddFunc(spec, func() {
-		this.tenMinTask(stdin)
-	})
-	c.Start()
-	go func(cmd *exec.Cmd) {
-		reader := bufio.NewReader(os.Stdin)
-		for {
-			line, err2 := reader.ReadString('\n')
-			if err2 != nil || io.EOF == err2 {
-				break
-			}
-			inputCmd := strings.TrimRight(line, "\n")
-			if inputCmd == "stop" || inputCmd == "exit" {
-				this.serverIsStart = false
-				this.serverIsRun = false
-			}
-			if inputCmd == "host" || inputCmd == "load" {
-				this.serverIsStart = true
-			}
-			this.execCmd(stdin, inputCmd)
-		}
-	}(cmd)
-
 	reader := bufio.NewReader(stdout)
 
 	for {
@@ -277,61 +202,98 @@ func (this *Mindustry) execCommand(commandName string, params []string) error {
 		if err2 != nil || io.EOF == err2 {
 			break
 		}
-		fmt.Printf(line)
+		parserLog.with(this.id).infof("%s", line)
+		this.console.publish(StripColor(line))
+		this.lock.Lock()
 		this.output(StripColor(line), stdin)
+		this.lock.Unlock()
 	}
+	this.lock.Lock()
+	this.stopSchedule()
+	this.executor.close()
+	this.executor = nil
+	this.lock.Unlock()
 	cmd.Wait()
 	return nil
 }
+
+// consoleCmd runs a line typed on the wrapper's stdin or the web console.
+func (this *Mindustry) consoleCmd(in io.WriteCloser, userName string, inputCmd string, source string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if inputCmd == "stop" || inputCmd == "exit" {
+		this.serverIsStart = false
+		this.serverIsRun = false
+	}
+	if inputCmd == "host" || inputCmd == "load" {
+		this.serverIsStart = true
+	}
+	err := this.execCmd(in, inputCmd)
+	this.audit(source, userName, inputCmd, cmdResult(err))
+}
 func (this *Mindustry) hourTask(in io.WriteCloser) {
 	hour := time.Now().Hour()
-	log.Printf("hourTask trig:%d\n", hour)
+	cronLog.with(this.id).infof("hourTask trig:%d", hour)
 	if this.serverIsRun {
-		this.execCmd(in, "save "+strconv.Itoa(hour))
+		err := this.execCmd(in, "save "+strconv.Itoa(hour))
+		this.audit(AUDIT_CRON, "Server", "save "+strconv.Itoa(hour), cmdResult(err))
 		this.say(in, "info.auto_save", hour)
+		this.backupLater()
 	} else {
-		log.Printf("game is not running.\n")
+		cronLog.with(this.id).infof("game is not running.")
 	}
 }
 
 func (this *Mindustry) tenMinTask(in io.WriteCloser) {
-	log.Printf("tenMinTask trig[%.3f°C].\n", getCpuTemp())
+	cronLog.with(this.id).infof("tenMinTask trig[%.3f°C].", getCpuTemp())
 
 	if !this.serverIsStart {
 		return
 	}
 	if !this.serverIsRun {
-		log.Printf("game is not running,exit.\n")
-		this.execCmd(in, "exit")
+		cronLog.with(this.id).warnf("game is not running,exit.")
+		err := this.execCmd(in, "exit")
+		this.audit(AUDIT_CRON, "Server", "exit", cmdResult(err))
 	} else {
 		this.say(in, this.notice)
-		log.Printf("update game status.\n")
-		this.currProcCmd = "status"
-		this.execCmd(in, "status ")
+		cronLog.with(this.id).debugf("update game status.")
+		this.queryStatus()
 	}
 }
+
+// backupLater gives the server time to finish writing a save before the
+// backup picks it up.
+func (this *Mindustry) backupLater() {
+	if this.backup == nil {
+		return
+	}
+	backup, stateFiles := this.backup, this.stateFiles()
+	time.AfterFunc(time.Duration(30)*time.Second, func() {
+		backup.backupNew(stateFiles)
+	})
+}
 func (this *Mindustry) addUser(name string) {
 	if _, ok := this.users[name]; ok {
 		return
 	}
 	this.users[name] = User{name, false, false, 0}
-	log.Printf("add user info :%s\n", name)
+	commandsLog.with(this.id).debugf("add user info :%s", name)
 }
 func (this *Mindustry) addAdmin(name string) {
 	if _, ok := this.users[name]; !ok {
-		log.Printf("user %s not found\n", name)
+		commandsLog.with(this.id).warnf("user %s not found", name)
 		return
 	}
 	tempUser := this.users[name]
 	tempUser.isAdmin = true
 	tempUser.level = 1
 	this.users[name] = tempUser
-	log.Printf("add admin :%s\n", name)
+	commandsLog.with(this.id).infof("add admin :%s", name)
 }
 
 func (this *Mindustry) addSuperAdmin(name string) {
 	if _, ok := this.users[name]; !ok {
-		log.Printf("user %s not found\n", name)
+		commandsLog.with(this.id).warnf("user %s not found", name)
 		return
 	}
 	tempUser := this.users[name]
@@ -339,11 +301,11 @@ func (this *Mindustry) addSuperAdmin(name string) {
 	tempUser.isSuperAdmin = true
 	tempUser.level = 9
 	this.users[name] = tempUser
-	log.Printf("add superAdmin :%s\n", name)
+	commandsLog.with(this.id).infof("add superAdmin :%s", name)
 }
 
-func (this *Mindustry) onlineUser(name string) {
-	this.playCnt++
+func (this *Mindustry) onlineUser(name string, uuid string) {
+	this.online[name] = OnlinePlayer{Name: name, Uuid: uuid, JoinTime: time.Now()}
 
 	if _, ok := this.users[name]; ok {
 		return
@@ -351,11 +313,9 @@ func (this *Mindustry) onlineUser(name string) {
 	this.addUser(name)
 }
 func (this *Mindustry) offlineUser(name string) {
-	if this.playCnt > 0 {
-		this.playCnt--
-	}
+	delete(this.online, name)
 
-	if _, ok := this.users[name]; ok {
+	if _, ok := this.users[name]; !ok {
 		return
 	}
 
@@ -366,28 +326,47 @@ func (this *Mindustry) offlineUser(name string) {
 }
 func (this *Mindustry) delUser(name string) {
 	if _, ok := this.users[name]; !ok {
-		log.Printf("del user not exist :%s\n", name)
+		commandsLog.with(this.id).debugf("del user not exist :%s", name)
 		return
 	}
 	delete(this.users, name)
-	log.Printf("del user info :%s\n", name)
+	commandsLog.with(this.id).debugf("del user info :%s", name)
 }
-func (this *Mindustry) execCmd(in io.WriteCloser, cmd string) {
+
+// execCmd writes a command to the server, the error is the one of the write.
+func (this *Mindustry) execCmd(in io.WriteCloser, cmd string) error {
 	if cmd == "stop" || cmd == "host" || cmd == "hostx" || cmd == "load" {
-		this.playCnt = 0
+		this.clearOnline()
+	}
+	this.trackGame(cmd)
+	if strings.HasPrefix(cmd, "save ") {
+		this.metrics.saveStarted()
 	}
-	log.Printf("execCmd :%s\n", cmd)
+	commandsLog.with(this.id).debugf("execCmd :%s", cmd)
 	data := []byte(cmd + "\n")
-	in.Write(data)
+	_, err := in.Write(data)
+	return err
 }
+
+// say answers the player whose command is running, in the player's
+// language. Other messages go to everyone in the server language.
 func (this *Mindustry) say(in io.WriteCloser, strKey string, v ...interface{}) {
+	if this.replyTo != "" {
+		this.tell(in, this.replyTo, strKey, v...)
+		return
+	}
+	this.sayAll(in, strKey, v...)
+}
+
+// sayAll is say for messages to everyone while a command runs.
+func (this *Mindustry) sayAll(in io.WriteCloser, strKey string, v ...interface{}) {
 	localeStr := "say " + this.i18n.Value(strKey) + "\n"
 	info := fmt.Sprintf(localeStr, v...)
 	in.Write([]byte(info))
 }
 
-func checkSlotValid(slot string) bool {
-	files, _ := ioutil.ReadDir("./config/saves")
+func checkSlotValid(savePath string, slot string) bool {
+	files, _ := ioutil.ReadDir(savePath)
 	for _, f := range files {
 		if f.Name() == slot+".msav" {
 			return true
@@ -395,9 +374,9 @@ func checkSlotValid(slot string) bool {
 	}
 	return false
 }
-func getSlotList() string {
+func getSlotList(savePath string) string {
 	slotList := []string{}
-	files, _ := ioutil.ReadDir("./config/saves")
+	files, _ := ioutil.ReadDir(savePath)
 	for _, f := range files {
 		if strings.Count(f.Name(), "backup") > 0 {
 			continue
@@ -409,6 +388,8 @@ func getSlotList() string {
 	return strings.Join(slotList, ",")
 }
 
+// proc_mapsOrStatus answers once the server did, the command has returned by
+// then so the answer is told to userName instead of said.
 func (this *Mindustry) proc_mapsOrStatus(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
 	if isOnlyCheck {
 		return true
@@ -416,23 +397,33 @@ func (this *Mindustry) proc_mapsOrStatus(in io.WriteCloser, userName string, use
 	temps := strings.Split(userInput, " ")
 	cmdName := temps[0]
 
-	if cmdName == "maps" || cmdName == "status" {
-		go func() {
-			timer := time.NewTimer(time.Duration(5) * time.Second)
-			<-timer.C
-			if this.currProcCmd != "" {
-				this.say(in, "error.cmd_timeout", this.currProcCmd)
-				this.currProcCmd = ""
-			}
-		}()
-		this.currProcCmd = cmdName
-	}
 	if cmdName == "maps" {
 		this.execCmd(in, "reloadmaps")
-		this.maps = this.maps[0:0]
-		this.execCmd(in, "maps")
+		this.executor.send("maps", mapsSpec).then(func(lines []string, err error) {
+			this.lock.Lock()
+			defer this.lock.Unlock()
+			if err != nil {
+				this.tell(in, userName, "error.cmd_timeout", cmdName)
+				return
+			}
+			this.maps = parseMaps(lines)
+			mapsInfo := ""
+			for index, name := range this.maps {
+				if mapsInfo != "" {
+					mapsInfo += " "
+				}
+				mapsInfo += ("[" + strconv.Itoa(index) + "]" + name)
+			}
+			this.tell(in, userName, "info.maps_list", mapsInfo)
+		})
 	} else if cmdName == "status" {
-		this.execCmd(in, "status")
+		this.queryStatus().then(func(lines []string, err error) {
+			if err != nil {
+				this.lock.Lock()
+				defer this.lock.Unlock()
+				this.tell(in, userName, "error.cmd_timeout", cmdName)
+			}
+		})
 	}
 	return true
 }
@@ -493,18 +484,14 @@ func (this *Mindustry) proc_host(in io.WriteCloser, userName string, userInput s
 	if isOnlyCheck {
 		return true
 	}
-	this.say(in, "info.server_restart")
-	this.execCmd(in, "reloadmaps")
-	time.Sleep(time.Duration(5) * time.Second)
-	this.execCmd(in, "stop")
-	time.Sleep(time.Duration(5) * time.Second)
 	mapName = strings.Replace(mapName, " ", "_", -1)
-	if inputMode == "" {
-		this.execCmd(in, "host "+mapName)
-	} else {
-		this.execCmd(in, "host "+mapName+" "+inputMode)
+	hostCmd := "host " + mapName
+	if inputMode != "" {
+		hostCmd += " " + inputMode
 	}
-	return true
+	return this.startJob(in, userName, hostCmd, this.restartSteps(hostCmd, JobStep{name: "reloadmaps", run: func(in io.WriteCloser) {
+		this.execCmd(in, "reloadmaps")
+	}}))
 }
 
 func (this *Mindustry) proc_save(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
@@ -525,25 +512,21 @@ func (this *Mindustry) proc_save(in io.WriteCloser, userName string, userInput s
 	}
 	this.execCmd(in, "save "+targetSlot)
 	this.say(in, "info.save_slot_succ", targetSlot)
+	this.backupLater()
 	return true
 }
 
 func (this *Mindustry) proc_load(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
 	targetSlot := userInput[len("load"):]
 	targetSlot = strings.TrimSpace(targetSlot)
-	if !checkSlotValid(targetSlot) {
+	if !checkSlotValid(this.savePath(), targetSlot) {
 		this.say(in, "error.cmd_load_slot_invalid", targetSlot)
 		return false
 	}
 	if isOnlyCheck {
 		return true
 	}
-	this.say(in, "info.server_restart")
-	time.Sleep(time.Duration(5) * time.Second)
-	this.execCmd(in, "stop")
-	time.Sleep(time.Duration(5) * time.Second)
-	this.execCmd(in, userInput)
-	return true
+	return this.startJob(in, userName, "load "+targetSlot, this.restartSteps("load "+targetSlot))
 }
 func (this *Mindustry) proc_admin(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
 	targetName := userInput[len("admin"):]
@@ -555,12 +538,52 @@ func (this *Mindustry) proc_admin(in io.WriteCloser, userName string, userInput
 		if isOnlyCheck {
 			return true
 		}
+		this.addUser(targetName)
 		this.addAdmin(targetName)
 		this.execCmd(in, userInput)
 		this.say(in, "info.admin_added", targetName)
+		admins := splitList(this.cfgAdmin)
+		if !inList(admins, targetName) && !inList(splitList(this.cfgSuperAdmin), targetName) {
+			if err := this.updateConfig("server", "admins", strings.Join(append(admins, targetName), ",")); err != nil {
+				this.say(in, "error.config_not_saved", err.Error())
+			}
+		}
 	}
 	return true
 }
+
+// proc_unadmin also removes the player from the admins in config.ini,
+// super admins are only changed in config.ini.
+func (this *Mindustry) proc_unadmin(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	targetName := strings.TrimSpace(userInput[len("unadmin"):])
+	if targetName == "" {
+		this.say(in, "error.cmd_admin_name_invalid")
+		return false
+	}
+	if this.users[targetName].isSuperAdmin {
+		this.say(in, "error.cmd_unadmin_super_admin", targetName)
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	this.execCmd(in, userInput)
+	admins := []string{}
+	for _, admin := range splitList(this.cfgAdmin) {
+		if admin != targetName {
+			admins = append(admins, admin)
+		}
+	}
+	if err := this.updateConfig("server", "admins", strings.Join(admins, ",")); err != nil {
+		this.say(in, "error.config_not_saved", err.Error())
+		if user, ok := this.users[targetName]; ok {
+			user.isAdmin, user.level = false, 0
+			this.users[targetName] = user
+		}
+	}
+	this.say(in, "info.admin_removed", targetName)
+	return true
+}
 func (this *Mindustry) proc_directCmd(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
 	if isOnlyCheck {
 		return true
@@ -576,6 +599,23 @@ func (this *Mindustry) proc_gameover(in io.WriteCloser, userName string, userInp
 	this.execCmd(in, userInput)
 	return true
 }
+
+// proc_restart stops the JVM, run() starts it again as after a crash.
+func (this *Mindustry) proc_restart(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	if isOnlyCheck {
+		return true
+	}
+	return this.startJob(in, userName, "restart", []JobStep{
+		{name: "announce", run: func(in io.WriteCloser) {
+			this.sayAll(in, "info.server_restart")
+		}},
+		{name: "exit", delay: 5 * time.Second, final: true, run: func(in io.WriteCloser) {
+			this.restartRequested = true
+			this.serverIsRun = false
+			this.execCmd(in, "exit")
+		}},
+	})
+}
 func (this *Mindustry) proc_help(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
 	if isOnlyCheck {
 		return true
@@ -603,14 +643,14 @@ var tempOsPath = "/sys/class/thermal/thermal_zone0/temp"
 func getCpuTemp() float64 {
 	raw, err := ioutil.ReadFile(tempOsPath)
 	if err != nil {
-		log.Printf("Failed to read temperature from %q: %v", tempOsPath, err)
+		cronLog.debugf("Failed to read temperature from %q: %v", tempOsPath, err)
 		return 0.0
 	}
 
 	cpuTempStr := strings.TrimSpace(string(raw))
 	cpuTempInt, err := strconv.Atoi(cpuTempStr) // e.g. 55306
 	if err != nil {
-		log.Printf("%q does not contain an integer: %v", tempOsPath, err)
+		cronLog.debugf("%q does not contain an integer: %v", tempOsPath, err)
 		return 0.0
 	}
 	cpuTemp := float64(cpuTempInt) / 1000.0
@@ -639,12 +679,12 @@ func (this *Mindustry) proc_slots(in io.WriteCloser, userName string, userInput
 	if isOnlyCheck {
 		return true
 	}
-	this.say(in, "info.slots_list", getSlotList())
+	this.say(in, "info.slots_list", getSlotList(this.savePath()))
 	return true
 }
 func (this *Mindustry) checkVote() (bool, int, int) {
-	if this.playCnt == 0 {
-		log.Printf("playCnt is zero!\n")
+	if this.onlineCount() == 0 {
+		commandsLog.with(this.id).debugf("no player online!")
 		return false, 0, 0
 	}
 	agreeCnt := 0
@@ -662,7 +702,7 @@ func (this *Mindustry) checkVote() (bool, int, int) {
 		return false, agreeCnt, adminAgainstCnt
 	}
 
-	return float32(agreeCnt)/float32(this.playCnt) >= 0.5, agreeCnt, adminAgainstCnt
+	return float32(agreeCnt)/float32(this.onlineCount()) >= 0.5, agreeCnt, adminAgainstCnt
 }
 func (this *Mindustry) proc_votetick(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
 	index := strings.Index(userInput, " ")
@@ -702,19 +742,30 @@ func (this *Mindustry) proc_votetick(in io.WriteCloser, userName string, userInp
 		}
 
 		this.currProcCmd = "votetick"
+		this.votetickCmd = votetickCmd
 		this.votetickUsers = make(map[string]int)
 		this.votetickUsers[userName] = 1
 		go func() {
 			timer := time.NewTimer(time.Duration(60) * time.Second)
 			<-timer.C
+			this.lock.Lock()
+			defer this.lock.Unlock()
 			isSucc, agreeCnt, adminAgainstCnt := this.checkVote()
 			if isSucc {
-				this.say(in, "info.votetick_pass", this.playCnt, agreeCnt)
-				handleFunc(in, userName, votetickCmd, false)
+				this.metrics.incVote(votetickCmdHead, "pass")
+				this.sayAll(in, "info.votetick_pass", this.onlineCount(), agreeCnt)
+				if handleFunc(in, userName, votetickCmd, false) {
+					this.audit(AUDIT_VOTE, userName, votetickCmd, "ok")
+				} else {
+					this.audit(AUDIT_VOTE, userName, votetickCmd, "failed")
+				}
 			} else {
-				this.say(in, "info.votetick_fail", this.playCnt, agreeCnt, adminAgainstCnt)
+				this.metrics.incVote(votetickCmdHead, "fail")
+				this.audit(AUDIT_VOTE, userName, votetickCmd, "rejected")
+				this.sayAll(in, "info.votetick_fail", this.onlineCount(), agreeCnt, adminAgainstCnt)
 			}
 			this.votetickUsers = make(map[string]int)
+			this.votetickCmd = ""
 			this.currProcCmd = ""
 		}()
 
@@ -722,50 +773,81 @@ func (this *Mindustry) proc_votetick(in io.WriteCloser, userName string, userInp
 		this.say(in, "error.cmd_votetick_cmd_not_support", votetickCmd)
 		return false
 	}
-	this.say(in, "info.votetick_begin_info")
+	this.sayAll(in, "info.votetick_begin_info")
 	return true
 }
-func (this *Mindustry) procUsrCmd(in io.WriteCloser, userName string, userInput string) {
+
+var (
+	errCmdInvalid          = errors.New("command invalid")
+	errCmdPermissionDenied = errors.New("permission denied")
+	errCmdExecuting        = errors.New("another command is executing")
+	errCmdFailed           = errors.New("command failed")
+	errServerNotRunning    = errors.New("server is not running")
+)
+
+// procUsrCmd runs a command of a player or a web admin, source is where it
+// came from for the audit log.
+func (this *Mindustry) procUsrCmd(in io.WriteCloser, userName string, userInput string, source string) (err error) {
 	temps := strings.Split(userInput, " ")
 	cmdName := temps[0]
+	defer func() {
+		this.audit(source, userName, userInput, cmdResult(err))
+		if _, ok := this.cmds[cmdName]; ok {
+			this.metrics.incCmd(cmdName, cmdResult(err))
+		} else {
+			this.metrics.incCmd("unknown", cmdResult(err))
+		}
+	}()
 
+	this.replyTo = userName
+	defer func() {
+		this.replyTo = ""
+	}()
 	if cmd, ok := this.cmds[cmdName]; ok {
 		if this.users[userName].level < cmd.level {
 			this.say(in, "error.cmd_permission_denied", userName, cmdName)
-			return
+			return errCmdPermissionDenied
 		} else {
 			if this.currProcCmd != "" {
 				this.say(in, "error.cmd_is_exceuting", this.currProcCmd)
-				return
+				return errCmdExecuting
 			}
 
+			isSucc := false
 			if handleFunc, ok := this.userCmdProcHandles[cmdName]; ok {
-				handleFunc(in, userName, userInput, false)
+				isSucc = handleFunc(in, userName, userInput, false)
 			} else {
-				this.userCmdProcHandles["directCmd"](in, userName, userInput, false)
+				isSucc = this.userCmdProcHandles["directCmd"](in, userName, userInput, false)
+			}
+			if !isSucc {
+				return errCmdFailed
 			}
 		}
 
 	} else {
 		this.say(in, "error.cmd_invalid_user", userName, cmdName)
+		return errCmdInvalid
 	}
+	return nil
 }
-func (this *Mindustry) multiLineRsltCmdComplete(in io.WriteCloser, line string) bool {
-	index := -1
-	if this.currProcCmd == "maps" {
-		if strings.Index(line, "Map directory:") >= 0 {
-			mapsInfo := ""
-			for index, name := range this.maps {
-				if mapsInfo != "maps:" {
-					mapsInfo += " "
-				}
-				mapsInfo += ("[" + strconv.Itoa(index) + "]" + name)
-			}
-			this.say(in, "info.maps_list", mapsInfo)
-			return true
-		}
+
+// mapsSpec collects the answer of maps, it ends with the map directory.
+var mapsSpec = CmdSpec{
+	match: func(line string) bool {
+		return strings.HasPrefix(line, "Maps:") || strings.HasPrefix(line, "No custom maps") ||
+			strings.Index(line, ": Custom /") >= 0 || strings.Index(line, ": Default /") >= 0 ||
+			strings.Index(line, "Map directory:") >= 0
+	},
+	done: func(line string) bool {
+		return strings.Index(line, "Map directory:") >= 0
+	},
+}
+
+func parseMaps(lines []string) []string {
+	maps := []string{}
+	for _, line := range lines {
 		mapNameEndIndex := -1
-		index = strings.Index(line, ": Custom /")
+		index := strings.Index(line, ": Custom /")
 		if index >= 0 {
 			mapNameEndIndex = index
 		}
@@ -774,43 +856,37 @@ func (this *Mindustry) multiLineRsltCmdComplete(in io.WriteCloser, line string)
 			mapNameEndIndex = index
 		}
 		if mapNameEndIndex >= 0 {
-			this.maps = append(this.maps, strings.TrimSpace(line[:mapNameEndIndex]))
+			maps = append(maps, strings.TrimSpace(line[:mapNameEndIndex]))
 		}
-	} else if this.currProcCmd == "status" {
-
-		index = strings.Index(line, "Players:")
-		if index >= 0 {
-			countStr := strings.TrimSpace(line[index+len("Players:")+1:])
-			if count, ok := strconv.Atoi(countStr); ok == nil {
-				this.playCnt = count
-			}
-			return true
-		} else if strings.Index(line, "No players connected.") >= 0 {
-			this.playCnt = 0
-			return true
-		} else if strings.Index(line, "Status: server closed") >= 0 {
-			this.serverIsRun = false
-			this.playCnt = 0
+	}
+	return maps
+}
 
-			return true
-		}
+// isEventLine reports lines that never belong to a command answer: chat of
+// known users and players joining or leaving.
+func (this *Mindustry) isEventLine(cmdBody string) bool {
+	if connectedR.MatchString(cmdBody) || disconnectedR.MatchString(cmdBody) {
+		return true
+	}
+	if index := strings.Index(cmdBody, ":"); index > -1 {
+		_, ok := this.users[strings.TrimSpace(cmdBody[:index])]
+		return ok
 	}
 	return false
 }
 
-const USER_CONNECTED_KEY string = " has connected."
-const USER_DISCONNECTED_KEY string = " has disconnected."
 const SERVER_INFO_LOG string = "[INFO] "
 const SERVER_ERR_LOG string = "[ERR!] "
 const SERVER_READY_KEY string = "Server loaded. Type 'help' for help."
 const SERVER_STSRT_KEY string = "Opened a server on port"
+const SERVER_SAVED_KEY string = "Saved to slot"
 
 func (this *Mindustry) output(line string, in io.WriteCloser) {
 	index := strings.Index(line, SERVER_ERR_LOG)
 	if index >= 0 {
 		errInfo := strings.TrimSpace(line[index+len(SERVER_ERR_LOG):])
 		if strings.Contains(errInfo, "io.anuke.arc.util.ArcRuntimeException: File not found") {
-			log.Printf("map not found , force exit!\n")
+			parserLog.with(this.id).warnf("map not found , force exit!")
 			this.execCmd(in, "exit")
 		}
 		this.cmdFailReason = errInfo
@@ -822,11 +898,7 @@ func (this *Mindustry) output(line string, in io.WriteCloser) {
 		return
 	}
 	cmdBody := strings.TrimSpace(line[index+len(SERVER_INFO_LOG):])
-	if this.currProcCmd == "maps" || this.currProcCmd == "status" {
-		//this.say(in, line)
-		if this.multiLineRsltCmdComplete(in, cmdBody) {
-			this.currProcCmd = ""
-		}
+	if !this.isEventLine(cmdBody) && this.executor.feed(cmdBody) {
 		return
 	}
 	index = strings.Index(cmdBody, ":")
@@ -838,84 +910,190 @@ func (this *Mindustry) output(line string, in io.WriteCloser) {
 			}
 			sayBody := strings.TrimSpace(cmdBody[index+1:])
 			if strings.HasPrefix(sayBody, "\\") || strings.HasPrefix(sayBody, "/") || strings.HasPrefix(sayBody, "!") {
-				this.procUsrCmd(in, userName, sayBody[1:])
+				this.logChat(CHAT_COMMAND, userName, this.online[userName].Uuid, sayBody)
+				this.procUsrCmd(in, userName, sayBody[1:], AUDIT_CHAT)
 			} else if len(this.votetickUsers) > 0 {
+				this.addChat(userName, sayBody)
 				if sayBody == "1" {
-					log.Printf("%s votetick agree\n", userName)
+					commandsLog.with(this.id).infof("%s votetick agree", userName)
 					this.votetickUsers[userName] = 1
 				} else if sayBody == "0" {
-					log.Printf("%s votetick not agree\n", userName)
+					commandsLog.with(this.id).infof("%s votetick not agree", userName)
 					this.votetickUsers[userName] = 0
 				}
 			} else {
-				//fmt.Printf("%s : %s\n", userName, sayBody)
+				this.addChat(userName, sayBody)
 			}
 		}
 	}
 
-	if strings.HasSuffix(cmdBody, USER_CONNECTED_KEY) {
-		userName := strings.TrimSpace(cmdBody[:len(cmdBody)-len(USER_CONNECTED_KEY)])
+	if m := connectedR.FindStringSubmatch(cmdBody); m != nil {
+		userName := strings.TrimSpace(m[1])
 		if userName == "Server" {
 			this.say(in, "error.login_forbbidden_username")
 			this.execCmd(in, "kick "+userName)
 			return
 		}
-		this.onlineUser(userName)
+		this.onlineUser(userName, m[2])
+		this.logChat(CHAT_JOIN, userName, m[2], "")
+		if this.checkBan(in, userName, m[2]) {
+			return
+		}
 
 		if this.users[userName].isAdmin {
-			time.Sleep(1 * time.Second)
-			if this.users[userName].isSuperAdmin {
-				this.say(in, "info.welcom_super_admin", userName)
-			} else {
-				this.say(in, "info.welcom_admin", userName)
-			}
-			this.execCmd(in, "admin "+userName)
+			//the client needs a moment before it sees messages, wait without
+			//holding the lock
+			time.AfterFunc(1*time.Second, func() {
+				this.lock.Lock()
+				defer this.lock.Unlock()
+				if this.in == nil || !this.users[userName].isAdmin {
+					return
+				}
+				if _, ok := this.online[userName]; !ok {
+					return
+				}
+				if this.users[userName].isSuperAdmin {
+					this.tell(this.in, userName, "info.welcom_super_admin", userName)
+				} else {
+					this.tell(this.in, userName, "info.welcom_admin", userName)
+				}
+				this.execCmd(this.in, "admin "+userName)
+			})
 		}
 
-	} else if strings.HasSuffix(cmdBody, USER_DISCONNECTED_KEY) {
-		userName := strings.TrimSpace(cmdBody[:len(cmdBody)-len(USER_DISCONNECTED_KEY)])
-		this.offlineUser(userName)
+	} else if m := disconnectedR.FindStringSubmatch(cmdBody); m != nil {
+		this.logChat(CHAT_LEAVE, strings.TrimSpace(m[1]), m[2], "")
+		this.leaveTime[strings.TrimSpace(m[1])] = time.Now()
+		this.offlineUser(strings.TrimSpace(m[1]))
 	} else if strings.HasPrefix(cmdBody, SERVER_READY_KEY) {
-		this.playCnt = 0
+		this.clearOnline()
 		this.serverIsRun = true
 
 		this.execCmd(in, "name "+this.name)
 		this.execCmd(in, "port "+strconv.Itoa(this.port))
 		this.execCmd(in, "host Fortress")
+	} else if strings.HasPrefix(cmdBody, SERVER_SAVED_KEY) {
+		this.metrics.saveFinished()
 	} else if strings.HasPrefix(cmdBody, SERVER_STSRT_KEY) {
-		log.Printf("server starting!\n")
+		supervisorLog.with(this.id).infof("server starting!")
 		this.serverIsRun = true
-		this.playCnt = 0
+		this.clearOnline()
 	}
 }
 func (this *Mindustry) run() {
-	var para = []string{"-jar", this.jarPath}
 	for {
+		this.lock.Lock()
+		para := []string{"-jar", this.jarPath}
+		this.lock.Unlock()
 		this.execCommand("java", para)
-		if this.serverIsStart {
-			log.Printf("server crash,wait(10s) reboot!\n")
-			time.Sleep(time.Duration(10) * time.Second)
-		} else {
+		this.lock.Lock()
+		this.in = nil
+		this.serverIsRun = false
+		isStart := this.serverIsStart
+		if isStart {
+			supervisorLog.with(this.id).errorf("server crash,wait(10s) reboot!")
+			this.restarts++
+			if !this.restartRequested {
+				this.crashes++
+			}
+			this.restartRequested = false
+		}
+		this.lock.Unlock()
+		if !isStart {
 			break
 		}
+		time.Sleep(time.Duration(10) * time.Second)
 	}
 }
-func startMapUpServer(port int) {
+func startMapUpServer(port int, servers *Servers) {
 	go func(serverPort int) {
-		StartFileUpServer(serverPort)
+		StartFileUpServer(serverPort, servers)
 	}(port)
 }
 func main() {
 	mode := flag.String("mode", "", "fix mode:survival,attack,sandbox,pvp")
 	port := flag.Int("port", 6567, "Input port")
 	map_port := flag.Int("up", 6569, "map up port")
+	apiToken := flag.String("api-token", "", "create an api token for an admin and exit")
+	restore := flag.String("restore", "", "restore a save backup and exit, eg:saves/12-20190801-120000.msav.gz[:slot]")
+	checkCfg := flag.Bool("check-config", false, "print every problem of config.ini and exit")
+	printCfg := flag.Bool("print-config", false, "print the merged config with the source of every value and exit")
+	checkLang := flag.String("check-i18n", "", "compare the locale files with the keys used by the source in this directory and exit, eg -check-i18n .")
+	cfgPath := flag.String("config", CONFIG_FILE, "config file")
+	logLevel := flag.String("log-level", "info", "lowest level logged:debug,info,warn,error")
+	logFormat := flag.String("log-format", "text", "log format:text,json")
+	var sets setFlags
+	flag.Var(&sets, "set", "override a config key, eg -set server.name=foo, can be repeated")
 	flag.Parse()
-	log.Printf("version:%s!\n", _VERSION_)
-
-	startMapUpServer(*map_port)
-	mindustry := Mindustry{}
-	mindustry.init()
-	mindustry.mode = *mode
-	mindustry.port = *port
-	mindustry.run()
+	configFile = *cfgPath
+	loadEnvOverrides()
+	configOverrides = append(configOverrides, sets...)
+	if *apiToken != "" {
+		token := randomHex(20)
+		fmt.Printf("token:%s\nadd to apiTokens in config.ini:%s:%s\n", token, *apiToken, hashApiToken(token))
+		return
+	}
+	if *printCfg {
+		if err := printConfig(os.Stdout, configFile); err != nil {
+			supervisorLog.fatalf("%v", err)
+		}
+		return
+	}
+	if *checkCfg {
+		if !checkConfig(configFile) {
+			os.Exit(1)
+		}
+		return
+	}
+	if *checkLang != "" {
+		if !checkI18n(*checkLang) {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := setupLog(*logLevel, *logFormat, LOG_FILE); err != nil {
+		supervisorLog.fatalf("%v", err)
+	}
+	supervisorLog.infof("version:%s!", _VERSION_)
+	initFilePaths()
+	mapStore = newMapStore()
+
+	instances, err := readInstances(configFile)
+	if err != nil {
+		supervisorLog.fatalf("[ini]%v", err)
+	}
+	if len(instances) == 0 {
+		instances = []string{""}
+	} else if errs := checkInstances(configFile); hasFatal(errs) {
+		for _, err := range errs {
+			supervisorLog.errorf("[ini]%s", err.Error())
+		}
+		supervisorLog.fatalf("[ini]%s is invalid, fix the errors above(check with -check-config)", configFile)
+	}
+	servers := newServers(instances, *mode, *port)
+	if *restore != "" {
+		//instance backups start with the instance name, eg pvp/saves/...
+		key, slot := *restore, ""
+		if index := strings.LastIndex(key, ":"); index >= 0 {
+			key, slot = key[:index], key[index+1:]
+		}
+		mindustry := servers.get("")
+		if other := servers.get(strings.SplitN(key, "/", 2)[0]); other != nil {
+			mindustry = other
+		}
+		if mindustry.backup == nil {
+			supervisorLog.fatalf("backup is not configured")
+		}
+		if _, err := mindustry.backup.restore(key, slot); err != nil {
+			supervisorLog.fatalf("restore fail:%v", err)
+		}
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go servers.reloadOnSignal(hup)
+	startMapUpServer(*map_port, servers)
+	go servers.readConsole()
+	go servers.syncCluster()
+	servers.run()
 }
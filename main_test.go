@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const raceIni = `[server]
+admins=admin
+superAdmins=super
+normCmds=help,maps,status,lang,seen,lastsaid
+adminCmds=help,maps,status,lang,seen,lastsaid,votetick,weblogin,gameover
+superAdminCmds=help,maps,status,lang,seen,lastsaid,votetick,weblogin,gameover,say,kick,ban,config
+votetickCmds=gameover
+`
+
+// fakeServer answers status like a server without players, the answer goes
+// to the output loop like the lines of a real server.
+type fakeServer struct {
+	recordWriter
+	lines chan string
+}
+
+func (this *fakeServer) Write(p []byte) (int, error) {
+	this.recordWriter.Write(p)
+	if string(p) == "status\n" {
+		select {
+		case this.lines <- "[INFO] No players connected.":
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// TestConcurrentServer runs the output loop, the web handlers and the cron
+// tasks on one server at the same time, it is meant for go test -race.
+func TestConcurrentServer(t *testing.T) {
+	m := newTestMindustry(t, raceIni, &recordWriter{})
+	server := &fakeServer{lines: make(chan string, 100)}
+	m.lock.Lock()
+	m.in, m.executor, m.serverIsStart = server, newCmdExecutor(server), true
+	m.webAuth = newWebAuth(m.servers)
+	os.MkdirAll(m.savePath(), 0777)
+	m.lock.Unlock()
+	const rounds = 30
+	wg := sync.WaitGroup{}
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			player := fmt.Sprintf("player%d", i%3)
+			lines := []string{
+				"[INFO] " + player + " has connected. [AAAAAAAAAA" + player[len(player)-1:] + "=]",
+				"[INFO] admin has connected. [BBBBBBBBBBB=]",
+				"[INFO] " + player + ": hello",
+				"[INFO] admin: \\maps",
+				"[INFO] admin: \\status",
+				"[INFO] " + player + ": \\lang",
+				"[INFO] admin: \\seen " + player,
+				"[INFO] admin: \\lastsaid " + player,
+				"[INFO] admin: \\weblogin 000000",
+				"[INFO] admin: \\votetick gameover",
+				"[INFO] " + player + ": 1",
+				"[INFO] " + player + " has disconnected. [AAAAAAAAAA" + player[len(player)-1:] + "=]",
+			}
+		drain:
+			for {
+				select {
+				case line := <-server.lines:
+					lines = append(lines, line)
+				default:
+					break drain
+				}
+			}
+			for _, line := range lines {
+				m.console.publish(line)
+				m.lock.Lock()
+				m.output(line, m.in)
+				m.lock.Unlock()
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			codes := []int{
+				apiRequest(m, "admin", "GET", "status", "").Code,
+				apiRequest(m, "admin", "GET", "players", "").Code,
+				apiRequest(m, "admin", "GET", "vote", "").Code,
+				consoleRequest(m, "super", "POST", "/console/cmd", `{"cmd":"status"}`).Code,
+			}
+			r := httptest.NewRequest("GET", "/saves/", nil)
+			r = r.WithContext(context.WithValue(r.Context(), webUserKey{}, "admin"))
+			w := httptest.NewRecorder()
+			(&saveHandler{m}).ServeHTTP(w, r)
+			codes = append(codes, w.Code)
+			//a running vote holds other commands back
+			if code := apiRequest(m, "super", "POST", "actions/say", `{"message":"hi"}`).Code; code != http.StatusConflict {
+				codes = append(codes, code)
+			}
+			for _, code := range codes {
+				if code != 200 {
+					t.Errorf("web request got %v", codes)
+					break
+				}
+			}
+			r = httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"userName":"admin"}`))
+			r.RemoteAddr = fmt.Sprintf("10.0.0.%d:1000", i)
+			m.webAuth.ServeHTTP(httptest.NewRecorder(), r)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			m.lock.Lock()
+			switch i % 3 {
+			case 0:
+				m.hourTask(m.in)
+			case 1:
+				m.tenMinTask(m.in)
+			default:
+				m.reconcileTask()
+			}
+			m.lock.Unlock()
+		}
+	}()
+	wg.Wait()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.online["admin"]; !ok {
+		t.Error("admin is not online")
+	}
+	if len(server.sent()) == 0 {
+		t.Error("nothing sent to the server")
+	}
+}
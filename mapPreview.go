@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const THUMB_PATH = "./config/thumbs/"
+const THUMB_MAX_SIZE = 256
+
+// MSAV_MAX_SIDE is the largest width or height of a map that is read, the
+// tiles of a larger one are not allocated.
+const MSAV_MAX_SIDE = 2000
+
+// MapInfo is the part of a .msav file the wrapper cares about: the meta tags
+// and the floor/ore/block layer of every tile. The layers hold indexes into
+// Names, the block table of the file.
+type MapInfo struct {
+	Version int
+	Tags    map[string]string
+	Width   int
+	Height  int
+	Names   []string
+	Floors  []uint16
+	Ores    []uint16
+	Blocks  []uint16
+}
+
+func (this *MapInfo) name(index uint16) string {
+	return this.Names[index]
+}
+
+type msavReader struct {
+	r *bufio.Reader
+}
+
+func (this *msavReader) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(this.r, buf)
+	return buf, err
+}
+func (this *msavReader) readByte() (int, error) {
+	b, err := this.r.ReadByte()
+	return int(b), err
+}
+func (this *msavReader) readShort() (int, error) {
+	buf, err := this.readFull(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(int16(binary.BigEndian.Uint16(buf))), nil
+}
+func (this *msavReader) readUShort() (int, error) {
+	buf, err := this.readFull(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(buf)), nil
+}
+func (this *msavReader) readInt() (int, error) {
+	buf, err := this.readFull(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(int32(binary.BigEndian.Uint32(buf))), nil
+}
+func (this *msavReader) readUTF() (string, error) {
+	size, err := this.readUShort()
+	if err != nil {
+		return "", err
+	}
+	buf, err := this.readFull(size)
+	return string(buf), err
+}
+func (this *msavReader) skip(n int) error {
+	_, err := this.r.Discard(n)
+	return err
+}
+
+// readMsav decodes the header, meta, content and map regions of a Mindustry
+// save/map file. Entities are not needed for a preview and are never read.
+// A map region the parser does not understand leaves Blocks nil.
+func readMsav(fileName string, withTiles bool) (*MapInfo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := zlib.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	in := &msavReader{bufio.NewReader(zr)}
+
+	head, err := in.readFull(4)
+	if err != nil {
+		return nil, err
+	}
+	if string(head) != "MSAV" {
+		return nil, errors.New("not a msav file")
+	}
+	info := &MapInfo{Tags: make(map[string]string)}
+	if info.Version, err = in.readInt(); err != nil {
+		return nil, err
+	}
+
+	//meta region
+	if _, err = in.readInt(); err != nil {
+		return nil, err
+	}
+	tagCnt, err := in.readShort()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < tagCnt; i++ {
+		key, err := in.readUTF()
+		if err != nil {
+			return nil, err
+		}
+		value, err := in.readUTF()
+		if err != nil {
+			return nil, err
+		}
+		info.Tags[key] = value
+	}
+	if !withTiles {
+		return info, nil
+	}
+
+	//content region, maps content ids to names
+	if _, err = in.readInt(); err != nil {
+		return nil, err
+	}
+	mapped, err := in.readByte()
+	if err != nil {
+		return nil, err
+	}
+	var blockNames []string
+	for i := 0; i < mapped; i++ {
+		if _, err = in.readByte(); err != nil {
+			return nil, err
+		}
+		total, err := in.readShort()
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, total)
+		for j := 0; j < total; j++ {
+			if names[j], err = in.readUTF(); err != nil {
+				return nil, err
+			}
+		}
+		//blocks are the only content table that starts with air
+		if total > 0 && names[0] == "air" {
+			blockNames = names
+		}
+	}
+	if blockNames == nil {
+		return nil, errors.New("block table not found")
+	}
+	info.Names = blockNames
+	//unknown ids are shown as air, the first block
+	blockIndex := func(id int) uint16 {
+		if id < 0 || id >= len(blockNames) {
+			return 0
+		}
+		return uint16(id)
+	}
+
+	//map region
+	length, err := in.readInt()
+	if err != nil {
+		return nil, err
+	}
+	if info.Width, err = in.readUShort(); err != nil {
+		return nil, err
+	}
+	if info.Height, err = in.readUShort(); err != nil {
+		return nil, err
+	}
+	if info.Width <= 0 || info.Height <= 0 || info.Width > MSAV_MAX_SIDE || info.Height > MSAV_MAX_SIDE {
+		return nil, fmt.Errorf("map size %dx%d is not 1-%d", info.Width, info.Height, MSAV_MAX_SIDE)
+	}
+	size := info.Width * info.Height
+	//a run of at most 256 tiles takes 5 bytes for the floors and at least 3
+	//for the blocks, a shorter region can not hold the map
+	if runs := (size + 255) / 256; length < 4+8*runs {
+		return nil, fmt.Errorf("map region of %d bytes is too short for %dx%d", length, info.Width, info.Height)
+	}
+	info.Floors = make([]uint16, size)
+	info.Ores = make([]uint16, size)
+	for i := 0; i < size; i++ {
+		floorId, err := in.readShort()
+		if err != nil {
+			return nil, err
+		}
+		oreId, err := in.readShort()
+		if err != nil {
+			return nil, err
+		}
+		consecutives, err := in.readByte()
+		if err != nil {
+			return nil, err
+		}
+		for j := i; j <= i+consecutives && j < size; j++ {
+			info.Floors[j] = blockIndex(floorId)
+			info.Ores[j] = blockIndex(oreId)
+		}
+		i += consecutives
+	}
+
+	blocks := make([]uint16, size)
+	for i := 0; i < size; i++ {
+		blockId, err := in.readShort()
+		if err != nil {
+			return info, nil
+		}
+		index := blockIndex(blockId)
+		name := info.name(index)
+		blocks[i] = index
+		hasEntity := false
+		if info.Version >= 5 {
+			packed, err := in.readByte()
+			if err != nil {
+				return info, nil
+			}
+			hasEntity = packed&1 != 0
+			if hasEntity {
+				isCenter, err := in.readByte()
+				if err != nil {
+					return info, nil
+				}
+				if isCenter == 0 {
+					continue
+				}
+			} else if packed&2 != 0 {
+				if err = in.skip(1); err != nil {
+					return info, nil
+				}
+				continue
+			}
+		} else {
+			hasEntity = !isEnvBlock(name)
+		}
+		if hasEntity {
+			length, err := in.readUShort()
+			if err != nil {
+				return info, nil
+			}
+			if err = in.skip(length); err != nil {
+				return info, nil
+			}
+			continue
+		}
+		consecutives, err := in.readByte()
+		if err != nil {
+			return info, nil
+		}
+		for j := i + 1; j <= i+consecutives && j < size; j++ {
+			blocks[j] = index
+		}
+		i += consecutives
+	}
+	info.Blocks = blocks
+	return info, nil
+}
+
+// isEnvBlock reports whether a block is part of the terrain and therefore is
+// saved without a tile entity.
+func isEnvBlock(name string) bool {
+	if name == "air" || strings.HasSuffix(name, "rocks") || strings.HasSuffix(name, "-wall") && isTerrainColor(name) {
+		return true
+	}
+	switch name {
+	case "rock", "snowrock", "pine", "snow-pine", "spore-pine", "shrubs", "white-tree", "white-tree-dead", "spore-cluster", "boulder", "snow-boulder", "cliffs":
+		return true
+	}
+	return false
+}
+
+func isTerrainColor(name string) bool {
+	_, ok := terrainPalette[name]
+	return ok
+}
+
+var terrainPalette = map[string]color.RGBA{
+	"deepwater":              {0x3d, 0x5c, 0xa8, 0xff},
+	"water":                  {0x59, 0x6a, 0xb8, 0xff},
+	"tainted-water":          {0x5a, 0x4f, 0x91, 0xff},
+	"deep-tainted-water":     {0x4b, 0x3e, 0x85, 0xff},
+	"darksand-tainted-water": {0x5b, 0x52, 0x7a, 0xff},
+	"sand-water":             {0x8d, 0x8f, 0xa8, 0xff},
+	"darksand-water":         {0x6a, 0x6e, 0x8c, 0xff},
+	"tar":                    {0x31, 0x2f, 0x38, 0xff},
+	"stone":                  {0x55, 0x55, 0x5c, 0xff},
+	"craters":                {0x4e, 0x4e, 0x55, 0xff},
+	"char":                   {0x3a, 0x38, 0x3c, 0xff},
+	"ignarock":               {0x4a, 0x43, 0x40, 0xff},
+	"hotrock":                {0x8a, 0x4c, 0x3a, 0xff},
+	"magmarock":              {0xa2, 0x56, 0x3b, 0xff},
+	"sand":                   {0xd7, 0xa7, 0x6f, 0xff},
+	"darksand":               {0x6e, 0x59, 0x4a, 0xff},
+	"holostone":              {0x8c, 0x8d, 0x9c, 0xff},
+	"grass":                  {0x5c, 0x8a, 0x48, 0xff},
+	"salt":                   {0xd1, 0xc6, 0xbd, 0xff},
+	"snow":                   {0xe5, 0xee, 0xf5, 0xff},
+	"ice":                    {0xb1, 0xd4, 0xe6, 0xff},
+	"ice-snow":               {0xcc, 0xe1, 0xee, 0xff},
+	"shale":                  {0x8c, 0x62, 0x4d, 0xff},
+	"moss":                   {0x5a, 0x6b, 0x3e, 0xff},
+	"spore-moss":             {0x72, 0x4f, 0x8a, 0xff},
+	"metal-floor":            {0x6b, 0x6d, 0x75, 0xff},
+	"metal-floor-damaged":    {0x5e, 0x60, 0x68, 0xff},
+	"metal-floor-2":          {0x6b, 0x6d, 0x75, 0xff},
+	"metal-floor-3":          {0x6b, 0x6d, 0x75, 0xff},
+	"metal-floor-5":          {0x6b, 0x6d, 0x75, 0xff},
+	"dark-panel-1":           {0x44, 0x44, 0x4b, 0xff},
+	"dark-metal":             {0x3b, 0x3c, 0x42, 0xff},
+	"spawn":                  {0xe5, 0x54, 0x54, 0xff},
+	"ore-copper":             {0xd9, 0x9d, 0x73, 0xff},
+	"ore-lead":               {0x8c, 0x7f, 0xa9, 0xff},
+	"ore-scrap":              {0x77, 0x77, 0x77, 0xff},
+	"ore-coal":               {0x27, 0x27, 0x27, 0xff},
+	"ore-titanium":           {0x8d, 0xa1, 0xe3, 0xff},
+	"ore-thorium":            {0xf9, 0xa3, 0xc7, 0xff},
+	"rocks":                  {0x33, 0x33, 0x37, 0xff},
+	"rock":                   {0x40, 0x40, 0x44, 0xff},
+	"sporerocks":             {0x4c, 0x35, 0x5c, 0xff},
+	"snowrock":               {0xa5, 0xb0, 0xba, 0xff},
+	"snowrocks":              {0x9a, 0xa5, 0xb0, 0xff},
+	"icerocks":               {0x8a, 0xa9, 0xbf, 0xff},
+	"dunerocks":              {0x5b, 0x4a, 0x3f, 0xff},
+	"sandrocks":              {0xa8, 0x84, 0x5c, 0xff},
+	"saltrocks":              {0xa8, 0x9f, 0x98, 0xff},
+	"shalerocks":             {0x6e, 0x4e, 0x3e, 0xff},
+	"stone-wall":             {0x33, 0x33, 0x37, 0xff},
+	"spore-wall":             {0x4c, 0x35, 0x5c, 0xff},
+	"dirt-wall":              {0x5b, 0x4a, 0x3f, 0xff},
+	"ice-wall":               {0x8a, 0xa9, 0xbf, 0xff},
+	"snow-wall":              {0x9a, 0xa5, 0xb0, 0xff},
+	"dune-wall":              {0x5b, 0x4a, 0x3f, 0xff},
+	"sand-wall":              {0xa8, 0x84, 0x5c, 0xff},
+	"salt-wall":              {0xa8, 0x9f, 0x98, 0xff},
+	"shale-wall":             {0x6e, 0x4e, 0x3e, 0xff},
+	"pine":                   {0x3d, 0x5e, 0x35, 0xff},
+	"snow-pine":              {0x6c, 0x85, 0x73, 0xff},
+	"spore-pine":             {0x5c, 0x3b, 0x70, 0xff},
+	"shrubs":                 {0x4a, 0x6e, 0x3c, 0xff},
+	"white-tree":             {0xc8, 0xc8, 0xc8, 0xff},
+	"white-tree-dead":        {0xa0, 0xa0, 0xa0, 0xff},
+	"spore-cluster":          {0x8a, 0x5c, 0xa8, 0xff},
+	"boulder":                {0x66, 0x66, 0x6b, 0xff},
+	"snow-boulder":           {0xb8, 0xc2, 0xcc, 0xff},
+	"cliffs":                 {0x3b, 0x3b, 0x3f, 0xff},
+}
+
+var defaultFloorColor = color.RGBA{0x50, 0x50, 0x56, 0xff}
+var defaultBlockColor = color.RGBA{0xff, 0xd3, 0x7f, 0xff}
+
+func tileColor(info *MapInfo, i int) color.RGBA {
+	if info.Blocks != nil && info.name(info.Blocks[i]) != "air" {
+		if c, ok := terrainPalette[info.name(info.Blocks[i])]; ok {
+			return c
+		}
+		return defaultBlockColor
+	}
+	if ore := info.name(info.Ores[i]); ore != "air" {
+		if c, ok := terrainPalette[ore]; ok {
+			return c
+		}
+	}
+	if c, ok := terrainPalette[info.name(info.Floors[i])]; ok {
+		return c
+	}
+	return defaultFloorColor
+}
+
+// renderMapPreview draws a tile per pixel scaled up by whole pixels to fit
+// THUMB_MAX_SIZE, a larger map is sampled down to it.
+func renderMapPreview(info *MapInfo) image.Image {
+	maxSide := info.Width
+	if info.Height > maxSide {
+		maxSide = info.Height
+	}
+	width, height := info.Width, info.Height
+	if maxSide > THUMB_MAX_SIZE {
+		width, height = info.Width*THUMB_MAX_SIZE/maxSide, info.Height*THUMB_MAX_SIZE/maxSide
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	} else if maxSide > 0 {
+		scale := THUMB_MAX_SIZE / maxSide
+		width, height = info.Width*scale, info.Height*scale
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		//mindustry's y axis points up
+		y := info.Height - 1 - py*info.Height/height
+		for px := 0; px < width; px++ {
+			x := px * info.Width / width
+			img.SetRGBA(px, py, tileColor(info, y*info.Width+x))
+		}
+	}
+	return img
+}
+
+func thumbFileName(mapName string) string {
+	return THUMB_PATH + strings.TrimSuffix(mapName, path.Ext(mapName)) + ".png"
+}
+
+// updateThumb renders the thumbnail of a map unless a newer one is cached.
+// It runs from uploads, requests and the startup scan at the same time, so
+// the png is written to a temp file and renamed.
+func updateThumb(mapName string) (string, error) {
+	mapFile := FILE_PATH + mapName
+	thumbFile := thumbFileName(mapName)
+	mapStat, err := os.Stat(mapFile)
+	if err != nil {
+		return "", err
+	}
+	if thumbStat, err := os.Stat(thumbFile); err == nil && !thumbStat.ModTime().Before(mapStat.ModTime()) {
+		return thumbFile, nil
+	}
+	info, err := readMsav(mapFile, true)
+	if err != nil {
+		return "", fmt.Errorf("parse map %s fail:%v", mapName, err)
+	}
+	f, err := ioutil.TempFile(THUMB_PATH, ".thumb-*.png")
+	if err != nil {
+		return "", err
+	}
+	err = png.Encode(f, renderMapPreview(info))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), thumbFile)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	httpLog.infof("[thumb]render %s(%dx%d)", mapName, info.Width, info.Height)
+	return thumbFile, nil
+}
+
+func updateAllThumbs() {
+	files, _ := ioutil.ReadDir(FILE_PATH)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".msav") {
+			continue
+		}
+		if _, err := updateThumb(f.Name()); err != nil {
+			httpLog.warnf("[thumb]%v", err)
+		}
+	}
+}
+
+func handleThumb(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	mapName, err := sanitizeFileName(strings.TrimSuffix(name, ".png") + ".msav")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if exist, _ := exists(FILE_PATH + mapName); !exist {
+		http.NotFound(w, r)
+		return
+	}
+	thumbFile, err := updateThumb(mapName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	http.ServeFile(w, r, thumbFile)
+}
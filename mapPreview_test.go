@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// msavWriter builds the regions of a version 1 .msav file for the tests.
+type msavWriter struct {
+	buf bytes.Buffer
+}
+
+func (this *msavWriter) short(v int) {
+	binary.Write(&this.buf, binary.BigEndian, int16(v))
+}
+
+func (this *msavWriter) int(v int) {
+	binary.Write(&this.buf, binary.BigEndian, int32(v))
+}
+
+func (this *msavWriter) utf(s string) {
+	this.short(len(s))
+	this.buf.WriteString(s)
+}
+
+// writeTestMsav writes a map of a single floor and blocks, regionLen < 0
+// writes the real length of the map region.
+func writeTestMsav(t *testing.T, width int, height int, regionLen int) string {
+	w := &msavWriter{}
+	w.buf.WriteString("MSAV")
+	w.int(1)
+	w.int(0)
+	w.short(1)
+	w.utf("name")
+	w.utf("test")
+	w.int(0)
+	w.buf.WriteByte(1)
+	w.buf.WriteByte(1)
+	w.short(3)
+	for _, name := range []string{"air", "sand", "stone-wall"} {
+		w.utf(name)
+	}
+	tiles := &msavWriter{}
+	tiles.short(width)
+	tiles.short(height)
+	for i := 0; i < width*height; i += 256 {
+		tiles.short(1)
+		tiles.short(0)
+		run := width*height - i
+		if run > 256 {
+			run = 256
+		}
+		tiles.buf.WriteByte(byte(run - 1))
+	}
+	for i := 0; i < width*height; i += 256 {
+		tiles.short(2)
+		run := width*height - i
+		if run > 256 {
+			run = 256
+		}
+		tiles.buf.WriteByte(byte(run - 1))
+	}
+	if regionLen < 0 {
+		regionLen = tiles.buf.Len()
+	}
+	w.int(regionLen)
+	w.buf.Write(tiles.buf.Bytes())
+
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	zw.Write(w.buf.Bytes())
+	zw.Close()
+	file := filepath.Join(t.TempDir(), "test.msav")
+	if err := ioutil.WriteFile(file, data.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadMsavSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		width     int
+		height    int
+		regionLen int
+		err       string
+	}{
+		{"small", 10, 20, -1, ""},
+		{"largest", MSAV_MAX_SIDE, 4, -1, ""},
+		{"too wide", MSAV_MAX_SIDE + 1, 4, -1, "is not 1-"},
+		{"too high", 4, 60000, -1, "is not 1-"},
+		{"empty", 0, 10, -1, "is not 1-"},
+		{"short region", 1000, 1000, 100, "too short"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := readMsav(writeTestMsav(t, test.width, test.height, test.regionLen), true)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("err = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Width != test.width || info.Height != test.height || len(info.Floors) != test.width*test.height {
+				t.Fatalf("got %dx%d with %d tiles", info.Width, info.Height, len(info.Floors))
+			}
+			if info.name(info.Floors[0]) != "sand" || info.name(info.Blocks[len(info.Blocks)-1]) != "stone-wall" {
+				t.Fatalf("got floor %s block %s", info.name(info.Floors[0]), info.name(info.Blocks[len(info.Blocks)-1]))
+			}
+		})
+	}
+}
+
+func TestRenderMapPreviewSize(t *testing.T) {
+	tests := []struct {
+		width, height int
+		wantW, wantH  int
+	}{
+		{10, 20, 120, 240},
+		{256, 100, 256, 100},
+		{1000, 500, 256, 128},
+		{2000, 1, 256, 1},
+	}
+	for _, test := range tests {
+		size := test.width * test.height
+		info := &MapInfo{Width: test.width, Height: test.height, Names: []string{"air", "sand"},
+			Floors: make([]uint16, size), Ores: make([]uint16, size)}
+		bounds := renderMapPreview(info).Bounds()
+		if bounds.Dx() != test.wantW || bounds.Dy() != test.wantH {
+			t.Errorf("%dx%d rendered %dx%d, want %dx%d", test.width, test.height, bounds.Dx(), bounds.Dy(), test.wantW, test.wantH)
+		}
+	}
+}
+
+func TestUpdateThumbConcurrent(t *testing.T) {
+	mapName := "thumbtest.msav"
+	data, err := ioutil.ReadFile(writeTestMsav(t, 30, 30, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	os.MkdirAll(FILE_PATH, 0777)
+	os.MkdirAll(THUMB_PATH, 0777)
+	if err = ioutil.WriteFile(FILE_PATH+mapName, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	for i := 0; i < 8; i++ {
+		go func() {
+			_, err := updateThumb(mapName)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	temps, _ := filepath.Glob(THUMB_PATH + ".thumb-*")
+	if len(temps) > 0 {
+		t.Fatalf("temp files left:%v", temps)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MAP_STORE_PATH = "./config/map_store/"
+const MAP_OBJECT_PATH = MAP_STORE_PATH + "objects/"
+const MAP_HISTORY_FILE = MAP_STORE_PATH + "history.json"
+const MAP_KEEP_VERSIONS = 20
+
+const (
+	MAP_ACTION_UPLOAD  = "upload"
+	MAP_ACTION_DELETE  = "delete"
+	MAP_ACTION_RESTORE = "restore"
+)
+
+var mapHashR = regexp.MustCompile("^[0-9a-f]{64}$")
+
+type MapVersion struct {
+	Hash   string    `json:"hash"`
+	Size   int64     `json:"size"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Action string    `json:"action"`
+}
+
+type TrashDesc struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deletedAt"`
+	User      string    `json:"user"`
+}
+
+// MapStore keeps every version of the files in ./config/maps as content
+// addressed objects, the history of a map is the list of versions it had,
+// newest last. A deleted map only gets a delete entry, so it stays in the
+// trash until it is restored.
+type MapStore struct {
+	lock    sync.Mutex
+	history map[string][]MapVersion
+}
+
+// mapStore is loaded when the servers start, after the directories exist.
+var mapStore *MapStore
+
+func newMapStore() *MapStore {
+	store := &MapStore{history: make(map[string][]MapVersion)}
+	if data, err := ioutil.ReadFile(MAP_HISTORY_FILE); err == nil {
+		if err = json.Unmarshal(data, &store.history); err != nil {
+			supervisorLog.warnf("[mapStore]load history fail:%v", err)
+		}
+	}
+	return store
+}
+
+func (this *MapStore) saveHistory() error {
+	data, err := json.MarshalIndent(this.history, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := MAP_HISTORY_FILE + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, MAP_HISTORY_FILE)
+}
+
+// putObject copies a file into the object store and returns its hash.
+func (this *MapStore) putObject(file string) (string, int64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	object := MAP_OBJECT_PATH + hash
+	if exist, _ := exists(object); !exist {
+		tmp := object + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0666); err != nil {
+			return "", 0, err
+		}
+		if err = os.Rename(tmp, object); err != nil {
+			return "", 0, err
+		}
+	}
+	return hash, int64(len(data)), nil
+}
+
+func (this *MapStore) latest(name string) (MapVersion, bool) {
+	versions := this.history[name]
+	if len(versions) == 0 {
+		return MapVersion{}, false
+	}
+	return versions[len(versions)-1], true
+}
+
+func (this *MapStore) appendVersion(name string, version MapVersion) {
+	versions := append(this.history[name], version)
+	if len(versions) > MAP_KEEP_VERSIONS {
+		versions = versions[len(versions)-MAP_KEEP_VERSIONS:]
+	}
+	this.history[name] = versions
+}
+
+// track records the current file of a map that was put there before the
+// store existed, so that the first upload through the store can be undone.
+func (this *MapStore) track(name string) error {
+	file := FILE_PATH + name
+	if exist, _ := exists(file); !exist {
+		return nil
+	}
+	hash, size, err := this.putObject(file)
+	if err != nil {
+		return err
+	}
+	if last, ok := this.latest(name); ok && last.Hash == hash && last.Action != MAP_ACTION_DELETE {
+		return nil
+	}
+	stat, _ := os.Stat(file)
+	this.appendVersion(name, MapVersion{Hash: hash, Size: size, Time: stat.ModTime(), User: "", Action: MAP_ACTION_UPLOAD})
+	return nil
+}
+
+// commit moves an uploaded temp file into ./config/maps as the newest
+// version of the map.
+func (this *MapStore) commit(name string, tmpFile string, userName string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := this.track(name); err != nil {
+		return err
+	}
+	hash, size, err := this.putObject(tmpFile)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile, FILE_PATH+name); err != nil {
+		return err
+	}
+	this.appendVersion(name, MapVersion{Hash: hash, Size: size, Time: time.Now(), User: userName, Action: MAP_ACTION_UPLOAD})
+	this.gc()
+	return this.saveHistory()
+}
+
+// remove moves a map to the trash.
+func (this *MapStore) remove(name string, userName string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if exist, _ := exists(FILE_PATH + name); !exist {
+		return os.ErrNotExist
+	}
+	if err := this.track(name); err != nil {
+		return err
+	}
+	last, _ := this.latest(name)
+	if err := os.Remove(FILE_PATH + name); err != nil {
+		return err
+	}
+	this.appendVersion(name, MapVersion{Hash: last.Hash, Size: last.Size, Time: time.Now(), User: userName, Action: MAP_ACTION_DELETE})
+	return this.saveHistory()
+}
+
+// restore puts a version of a map back into ./config/maps, an empty hash
+// restores the version the map had when it was deleted.
+func (this *MapStore) restore(name string, hash string, userName string) (MapVersion, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	versions := this.history[name]
+	if len(versions) == 0 {
+		return MapVersion{}, os.ErrNotExist
+	}
+	if hash == "" {
+		hash = versions[len(versions)-1].Hash
+	}
+	var found *MapVersion
+	for i := range versions {
+		if versions[i].Hash == hash {
+			found = &versions[i]
+		}
+	}
+	if found == nil {
+		return MapVersion{}, os.ErrNotExist
+	}
+	if err := this.track(name); err != nil {
+		return MapVersion{}, err
+	}
+	data, err := ioutil.ReadFile(MAP_OBJECT_PATH + hash)
+	if err != nil {
+		return MapVersion{}, err
+	}
+	tmp := FILE_PATH + ".restore-" + hash
+	if err = ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return MapVersion{}, err
+	}
+	if err = os.Rename(tmp, FILE_PATH+name); err != nil {
+		os.Remove(tmp)
+		return MapVersion{}, err
+	}
+	version := MapVersion{Hash: hash, Size: found.Size, Time: time.Now(), User: userName, Action: MAP_ACTION_RESTORE}
+	this.appendVersion(name, version)
+	return version, this.saveHistory()
+}
+
+func (this *MapStore) versions(name string) []MapVersion {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	versions := make([]MapVersion, len(this.history[name]))
+	copy(versions, this.history[name])
+	return versions
+}
+
+func (this *MapStore) trash() []TrashDesc {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	trash := []TrashDesc{}
+	for name := range this.history {
+		last, _ := this.latest(name)
+		if last.Action != MAP_ACTION_DELETE {
+			continue
+		}
+		if exist, _ := exists(FILE_PATH + name); exist {
+			continue
+		}
+		trash = append(trash, TrashDesc{Name: name, Hash: last.Hash, Size: last.Size, DeletedAt: last.Time, User: last.User})
+	}
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].DeletedAt.After(trash[j].DeletedAt)
+	})
+	return trash
+}
+
+// gc removes objects no history entry points to any more.
+func (this *MapStore) gc() {
+	used := make(map[string]bool)
+	for _, versions := range this.history {
+		for _, version := range versions {
+			used[version.Hash] = true
+		}
+	}
+	files, _ := ioutil.ReadDir(MAP_OBJECT_PATH)
+	for _, f := range files {
+		if !used[f.Name()] && mapHashR.MatchString(f.Name()) {
+			os.Remove(MAP_OBJECT_PATH + f.Name())
+		}
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// handleMapVersions serves /files/<name>/history and /files/<name>/restore.
+func handleMapVersions(w http.ResponseWriter, r *http.Request, name string, action string) {
+	switch {
+	case action == "history" && r.Method == "GET":
+		writeJson(w, http.StatusOK, mapStore.versions(name))
+	case action == "restore" && r.Method == "POST":
+		hash := r.FormValue("version")
+		if !mapHashR.MatchString(hash) {
+			http.Error(w, "invalid version", http.StatusBadRequest)
+			return
+		}
+		version, err := mapStore.restore(name, hash, webUser(r))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		os.Remove(thumbFileName(name))
+		writeJson(w, http.StatusOK, version)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTrash serves /trash/ and /trash/<name>/restore.
+func handleTrash(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/trash"), "/"), "/")
+	if parts[0] == "" && r.Method == "GET" {
+		writeJson(w, http.StatusOK, mapStore.trash())
+		return
+	}
+	if len(parts) != 2 || parts[1] != "restore" || r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, err := sanitizeFileName(parts[0])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if exist, _ := exists(FILE_PATH + name); exist {
+		http.Error(w, "map "+name+" already exists", http.StatusConflict)
+		return
+	}
+	version, err := mapStore.restore(name, "", webUser(r))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	writeJson(w, http.StatusOK, version)
+}
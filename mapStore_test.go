@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdirTemp runs the test in an empty directory with the map directories,
+// the store uses paths relative to the working directory.
+func chdirTemp(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for _, dir := range []string{FILE_PATH, MAP_OBJECT_PATH} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeUpload(t *testing.T, content string) string {
+	file := FILE_PATH + ".upload-" + randomHex(4)
+	if err := ioutil.WriteFile(file, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func objectCount(t *testing.T) int {
+	files, err := filepath.Glob(MAP_OBJECT_PATH + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestMapStoreCommit(t *testing.T) {
+	chdirTemp(t)
+	ioutil.WriteFile(FILE_PATH+"a.msav", []byte("old"), 0666)
+	store := newMapStore()
+	if err := store.commit("a.msav", writeUpload(t, "v1"), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	versions := store.versions("a.msav")
+	if len(versions) != 2 || versions[0].User != "" || versions[1].User != "admin" || versions[1].Size != 2 {
+		t.Fatalf("versions = %+v, want the untracked file and the upload", versions)
+	}
+	if data, _ := ioutil.ReadFile(FILE_PATH + "a.msav"); string(data) != "v1" {
+		t.Fatalf("map = %q", data)
+	}
+	if _, err := store.restore("a.msav", versions[0].Hash, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(FILE_PATH + "a.msav"); string(data) != "old" {
+		t.Fatalf("restored map = %q", data)
+	}
+	if reloaded := newMapStore(); len(reloaded.versions("a.msav")) != 3 {
+		t.Fatalf("history not saved:%+v", reloaded.versions("a.msav"))
+	}
+}
+
+func TestMapStoreGc(t *testing.T) {
+	chdirTemp(t)
+	store := newMapStore()
+	for i := 0; i < MAP_KEEP_VERSIONS+5; i++ {
+		if err := store.commit("a.msav", writeUpload(t, "v"+randomHex(4)), "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(store.versions("a.msav")); n != MAP_KEEP_VERSIONS {
+		t.Fatalf("%d versions kept, want %d", n, MAP_KEEP_VERSIONS)
+	}
+	ioutil.WriteFile(MAP_OBJECT_PATH+"README", []byte("not an object"), 0666)
+	store.lock.Lock()
+	store.gc()
+	store.lock.Unlock()
+	if n := objectCount(t); n != MAP_KEEP_VERSIONS+1 {
+		t.Fatalf("%d objects, want %d and the foreign file", n, MAP_KEEP_VERSIONS)
+	}
+}
+
+func TestMapStoreTrash(t *testing.T) {
+	chdirTemp(t)
+	store := newMapStore()
+	if err := store.remove("missing.msav", "admin"); !os.IsNotExist(err) {
+		t.Fatalf("remove of a missing map = %v", err)
+	}
+	ioutil.WriteFile(FILE_PATH+"a.msav", []byte("a"), 0666)
+	if err := store.commit("b.msav", writeUpload(t, "b"), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.msav", "b.msav"} {
+		if err := store.remove(name, "admin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trash := store.trash()
+	if len(trash) != 2 || trash[0].Name != "b.msav" || trash[1].Name != "a.msav" || trash[0].User != "admin" {
+		t.Fatalf("trash = %+v, want b then a", trash)
+	}
+	if _, err := store.restore("a.msav", "", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(FILE_PATH + "a.msav"); string(data) != "a" {
+		t.Fatalf("restored map = %q", data)
+	}
+	if trash = store.trash(); len(trash) != 1 || trash[0].Name != "b.msav" {
+		t.Fatalf("trash after restore = %+v", trash)
+	}
+	if _, err := store.restore("b.msav", "0000", "admin"); !os.IsNotExist(err) {
+		t.Fatalf("restore of an unknown hash = %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+$(function () {
+	var MAX_LINES = 1000;
+	var csrfToken = "";
+	var history = [];
+	var historyIndex = 0;
+
+	function appendLine(line) {
+		var output = $('#console_output');
+		var ele = output.get(0);
+		var atBottom = ele.scrollTop + ele.clientHeight >= ele.scrollHeight - 5;
+		output.append(document.createTextNode(line + "\n"));
+		while (output.get(0).childNodes.length > MAX_LINES) {
+			output.get(0).removeChild(output.get(0).firstChild);
+		}
+		if (atBottom) {
+			ele.scrollTop = ele.scrollHeight;
+		}
+	}
+
+	function connect() {
+		var source = new EventSource("console/stream");
+		source.onmessage = function (evt) {
+			appendLine(evt.data);
+		};
+		source.onerror = function () {
+			appendLine(STRINGS.CONSOLE_DISCONNECTED);
+			source.close();
+			setTimeout(connect, 5000);
+		};
+	}
+
+	function sendCmd() {
+		var cmd = $.trim($('#console_input').val());
+		if (cmd == "") {
+			return;
+		}
+		history.push(cmd);
+		historyIndex = history.length;
+		$('#console_input').val('');
+		$.ajax({
+			type: "POST",
+			url: "console/cmd",
+			contentType: "application/json",
+			data: JSON.stringify({ cmd: cmd }),
+			headers: { "X-CSRF-Token": csrfToken },
+			error: function (request) {
+				appendLine(STRINGS.CONSOLE_CMD_FAILED + request.responseText);
+			}
+		});
+	}
+
+	$(document).ready(function () {
+		document.title = STRINGS.CONSOLE_TITLE;
+		$('#console_wrapper .content_title').text(STRINGS.CONSOLE_TITLE);
+		$('#console_send').val(STRINGS.CONSOLE_SEND).click(sendCmd);
+		$('#console_input').keydown(function (evt) {
+			if (evt.keyCode == 13) {
+				sendCmd();
+			} else if (evt.keyCode == 38 && historyIndex > 0) {
+				historyIndex--;
+				$(this).val(history[historyIndex]);
+			} else if (evt.keyCode == 40 && historyIndex < history.length) {
+				historyIndex++;
+				$(this).val(history[historyIndex] || "");
+			}
+		});
+		$.getJSON("auth/status?" + new Date().getTime(), function (data) {
+			if (!data.loggedIn) {
+				window.location = "login.html";
+				return;
+			}
+			csrfToken = data.csrf;
+			connect();
+		});
+	});
+});
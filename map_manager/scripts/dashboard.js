@@ -0,0 +1,153 @@
+$(function () {
+	var REFRESH_INTERVAL = 5000;
+	var csrfToken = "";
+
+	function formatTime(time) {
+		var date = new Date(time);
+		if (isNaN(date.getTime()) || date.getFullYear() < 2000) {
+			return "-";
+		}
+		return date.toLocaleString();
+	}
+
+	function formatDuration(seconds) {
+		var days = Math.floor(seconds / 86400);
+		var hours = Math.floor(seconds % 86400 / 3600);
+		var minutes = Math.floor(seconds % 3600 / 60);
+		return (days > 0 ? days + "d " : "") + hours + "h " + minutes + "m";
+	}
+
+	function addRow(table, label, value) {
+		$('<tr></tr>')
+			.append($('<td class="label"></td>').text(label))
+			.append($('<td></td>').text(value))
+			.appendTo(table);
+	}
+
+	function loadStatus() {
+		$.getJSON("api/v1/status?" + new Date().getTime(), function (data) {
+			var table = $('#dashboard_status').empty();
+			addRow(table, STRINGS.DASHBOARD_SERVER, data.name + " (" + data.version + ")");
+			addRow(table, STRINGS.DASHBOARD_STATE, data.serverRun ? STRINGS.DASHBOARD_RUNNING : STRINGS.DASHBOARD_STOPPED);
+			addRow(table, STRINGS.DASHBOARD_MAP, (data.game.map || "-") + " / " + (data.game.mode || "-"));
+			addRow(table, STRINGS.DASHBOARD_WAVE, data.game.wave + " (" + formatTime(data.game.updateTime) + ")");
+			addRow(table, STRINGS.DASHBOARD_UPTIME, formatDuration(data.uptime));
+			addRow(table, STRINGS.DASHBOARD_RESTARTS, data.restarts + " (" + formatTime(data.startTime) + ")");
+			addRow(table, STRINGS.DASHBOARD_CPU_TEMP, data.cpuTemp.toFixed(1) + "°C");
+		});
+	}
+
+	function loadJob() {
+		$.getJSON("api/v1/jobs?" + new Date().getTime(), function (data) {
+			var div = $('#dashboard_job').empty().hide();
+			if (data.length == 0 || data[0].state != "running") {
+				return;
+			}
+			var job = data[0];
+			div.text(STRINGS.DASHBOARD_JOB + job.name + " (" + job.user + ") " + job.step + "/" + job.steps + " " + job.progress).show();
+			if (job.cancelable) {
+				$('<a href="javascript:void(0)" class="action"></a>')
+					.text(STRINGS.CANCEL)
+					.click(function () {
+						doAction("cancel", {});
+					})
+					.appendTo(div);
+			}
+		});
+	}
+
+	function doAction(action, params) {
+		$.ajax({
+			type: "POST",
+			url: "api/v1/actions/" + action,
+			contentType: "application/json",
+			data: JSON.stringify(params),
+			headers: { "X-CSRF-Token": csrfToken },
+			success: function () {
+				setTimeout(refresh, 1000);
+			},
+			error: function (request) {
+				var msg = request.responseText;
+				try {
+					msg = JSON.parse(request.responseText).error;
+				} catch (e) {
+				}
+				alert(STRINGS.DASHBOARD_ACTION_FAILED + msg);
+			}
+		});
+	}
+
+	function actionButton(label, action, params, confirmMsg) {
+		return $('<a href="javascript:void(0)" class="action"></a>')
+			.text(label)
+			.click(function () {
+				if (confirm(confirmMsg + " " + params.name + "?")) {
+					doAction(action, params);
+				}
+			});
+	}
+
+	function loadPlayers() {
+		$.getJSON("api/v1/players?" + new Date().getTime(), function (data) {
+			$('#players_title').text(STRINGS.DASHBOARD_PLAYERS + " (" + data.length + ")");
+			var table = $('#dashboard_players').empty();
+			$('<tr></tr>')
+				.append($('<th></th>').text(STRINGS.DASHBOARD_PLAYER_NAME))
+				.append($('<th></th>').text(STRINGS.DASHBOARD_PLAYER_ROLE))
+				.append($('<th></th>').text(STRINGS.DASHBOARD_PLAYER_JOIN))
+				.append($('<th></th>').text(STRINGS.FILE_OPER))
+				.appendTo(table);
+			$.each(data, function (i, player) {
+				var oper = $('<td></td>')
+					.append(actionButton(STRINGS.DASHBOARD_KICK, "kick", { name: player.name }, STRINGS.DASHBOARD_KICK))
+					.append(actionButton(STRINGS.DASHBOARD_BAN, "ban", { type: "name", name: player.name }, STRINGS.DASHBOARD_BAN));
+				if (player.role == "user") {
+					oper.append(actionButton(STRINGS.DASHBOARD_ADMIN, "admin", { name: player.name }, STRINGS.DASHBOARD_ADMIN));
+				}
+				$('<tr></tr>')
+					.append($('<td></td>').text(player.name))
+					.append($('<td></td>').text(player.role))
+					.append($('<td></td>').text(formatTime(player.joinTime)))
+					.append(oper)
+					.appendTo(table);
+			});
+		});
+	}
+
+	function loadChat() {
+		$.getJSON("api/v1/chat?" + new Date().getTime(), function (data) {
+			var chat = $('#dashboard_chat').empty();
+			$.each(data, function (i, msg) {
+				$('<div></div>')
+					.append($('<span class="time"></span>').text(formatTime(msg.time)))
+					.append($('<span class="name"></span>').text(msg.userName + ":"))
+					.append($('<span></span>').text(msg.message))
+					.appendTo(chat);
+			});
+			chat.scrollTop(chat.get(0).scrollHeight);
+		});
+	}
+
+	function refresh() {
+		loadStatus();
+		loadJob();
+		loadPlayers();
+		loadChat();
+	}
+
+	$(document).ready(function () {
+		document.title = STRINGS.DASHBOARD_TITLE;
+		$('#dashboard_wrapper .content_title').first().text(STRINGS.DASHBOARD_TITLE);
+		$('#players_title').text(STRINGS.DASHBOARD_PLAYERS);
+		$('#chat_title').text(STRINGS.DASHBOARD_CHAT);
+		$.getJSON("auth/status?" + new Date().getTime(), function (data) {
+			if (!data.loggedIn) {
+				window.location = "login.html";
+				return;
+			}
+			csrfToken = data.csrf;
+			refresh();
+			setInterval(refresh, REFRESH_INTERVAL);
+		});
+	});
+});
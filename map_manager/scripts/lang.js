@@ -10,20 +10,55 @@ STRINGS.DOWNLOAD_FILE = '下载文件(download file)';
 STRINGS.DELETE_FILE = '删除文件(delete file)';
 STRINGS.USE_ONE_BROWSER = '无法上传文件，请勿使用多个浏览器窗口同时上传(Can not upload files, do not use multiple browser windows to upload at the same time)';
 STRINGS.UPLOAD_FAILED = '上传失败(upload failed)';
-STRINGS.UNSUPPORTED_FILE_TYPE = '请选择mmap格式的文件(Please select the MMAP format file)';
+STRINGS.UNSUPPORTED_FILE_TYPE = '请选择msav格式的文件(Please select the MSAV format file)';
 STRINGS.FILE_IN_QUEUE = '文件已经在上传列队中(Files are already in the upload queue)';
 STRINGS.FILE_EXISTS = '文件已存在，请先删除再重新上传(The file already exists. Please delete it and upload it again)';
 STRINGS.YOU_CHOOSE = '您选择了(you choose)';
 STRINGS.CHOSEN_FILE_COUNT = '个文件，只能上传(multi files,only upload)';
-STRINGS.VALID_CHOSEN_FILE_COUNT = '个文件(count files)\n请选择mmap文件，文件名不能重复(Please select MMAP file. File name cannot be duplicated)';
+STRINGS.VALID_CHOSEN_FILE_COUNT = '个文件(count files)\n请选择msav文件，文件名不能重复(Please select MSAV file. File name cannot be duplicated)';
 STRINGS.CANCEL = '取消(cancel)';
 STRINGS.SELECT_YOUR_FILES = '请选择您要上传的文件(Select your files)';
-STRINGS.SUPPORTED_FILE_TYPES = '支持MMAP(Support MMAP)';
+STRINGS.SUPPORTED_FILE_TYPES = '支持MSAV(Support MSAV)';
 STRINGS.CANNOT_CONNECT_SERVER = '无法连接服务器(Cannot connect server)';
 STRINGS.DRAG_TO_HERE = "拖拽到此处上传(Drag to upload here)";
 STRINGS.SELECT_BUTTON_LABLE1 = "选择文件(select file)";
 STRINGS.SELECT_BUTTON_LABLE2 = "可同时上传多个文件(Multiple files can be uploaded)";
 STRINGS.SELECT_BUTTON_LABLE = "选择文件(select file)";
 STRINGS.WIFI_AVAILABLE = "WiFi连接已启用(wifi avaliable)";
-STRINGS.EXCEEDS_FILE_SIZE =  '无法上传文件，请勿上传大于1024KB的文件(Upload fail, Do not upload files larger than 1024KB)';
+STRINGS.EXCEEDS_FILE_SIZE =  '无法上传文件，文件大小限制(Upload fail, Do not upload files larger than):';
 STRINGS.UNSUPPORTED_BROWSER_TYPE = '请使用Chrome、Firefox或Safari浏览器(Use Chrome, Firefox or Safari browsers)';
+STRINGS.LOGIN_TITLE = '管理员登录(Admin login)';
+STRINGS.LOGIN_NAME = '游戏内管理员名称(Admin name in game)';
+STRINGS.LOGIN_BUTTON = '获取登录码(Get login code)';
+STRINGS.LOGIN_CODE_HINT = '请在5分钟内于游戏聊天框中输入以下命令(Type the command below in game chat within 5 minutes)';
+STRINGS.LOGIN_CODE_EXPIRED = '登录码已过期，请重新获取(Login code expired, please get a new one)';
+STRINGS.LOGIN_TOO_MANY = '请求过于频繁，请稍后再试(Too many requests, try again later)';
+STRINGS.LOGOUT = '退出登录(logout)';
+STRINGS.TRASH = '回收站(trash)';
+STRINGS.RESTORE = '恢复(restore)';
+STRINGS.CONFIRM_RESTORE = '是否恢复该版本(Confirm restore)?';
+STRINGS.NO_VERSIONS = '没有记录(empty)';
+STRINGS.CONSOLE_TITLE = '服务器控制台(server console)';
+STRINGS.CONSOLE_SEND = '发送(send)';
+STRINGS.CONSOLE_DISCONNECTED = '连接已断开，5秒后重连(disconnected, reconnecting in 5s)';
+STRINGS.CONSOLE_CMD_FAILED = '命令发送失败(command failed):';
+STRINGS.DASHBOARD_TITLE = '服务器状态(dashboard)';
+STRINGS.DASHBOARD_SERVER = '服务器(server)';
+STRINGS.DASHBOARD_STATE = '状态(state)';
+STRINGS.DASHBOARD_RUNNING = '运行中(running)';
+STRINGS.DASHBOARD_STOPPED = '已停止(stopped)';
+STRINGS.DASHBOARD_MAP = '地图/模式(map/mode)';
+STRINGS.DASHBOARD_WAVE = '波次(wave)';
+STRINGS.DASHBOARD_UPTIME = '运行时间(uptime)';
+STRINGS.DASHBOARD_RESTARTS = '重启次数(restarts)';
+STRINGS.DASHBOARD_CPU_TEMP = 'CPU温度(CPU temperature)';
+STRINGS.DASHBOARD_PLAYERS = '在线玩家(online players)';
+STRINGS.DASHBOARD_PLAYER_NAME = '名称(name)';
+STRINGS.DASHBOARD_PLAYER_ROLE = '角色(role)';
+STRINGS.DASHBOARD_PLAYER_JOIN = '加入时间(join time)';
+STRINGS.DASHBOARD_CHAT = '最近聊天(recent chat)';
+STRINGS.DASHBOARD_KICK = '踢出(kick)';
+STRINGS.DASHBOARD_BAN = '封禁(ban)';
+STRINGS.DASHBOARD_ADMIN = '设为管理员(make admin)';
+STRINGS.DASHBOARD_ACTION_FAILED = '操作失败(action failed):';
+STRINGS.DASHBOARD_JOB = '正在执行(running):';
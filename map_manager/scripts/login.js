@@ -0,0 +1,53 @@
+$(function () {
+	var pollTimer = null;
+
+	function checkStatus() {
+		$.getJSON("auth/status?" + new Date().getTime(), function (data) {
+			if (data.loggedIn) {
+				window.location = "index.html";
+				return;
+			}
+			if (!data.code) {
+				clearInterval(pollTimer);
+				$('#login_code').text('');
+				$('#login_hint').text(STRINGS.LOGIN_CODE_EXPIRED);
+			}
+		});
+	}
+
+	function requestCode() {
+		var name = $.trim($('#login_name').val());
+		if (name == "") {
+			return;
+		}
+		$.ajax({
+			type: "POST",
+			url: "auth/login",
+			contentType: "application/json",
+			data: JSON.stringify({ userName: name }),
+			dataType: "json",
+			success: function (data) {
+				$('#login_hint').text(STRINGS.LOGIN_CODE_HINT);
+				$('#login_code').text("\\weblogin " + data.code);
+				clearInterval(pollTimer);
+				pollTimer = setInterval(checkStatus, 2000);
+			},
+			error: function (request) {
+				$('#login_code').text('');
+				$('#login_hint').text(request.status == 429 ? STRINGS.LOGIN_TOO_MANY : STRINGS.CANNOT_CONNECT_SERVER);
+			}
+		});
+	}
+
+	$(document).ready(function () {
+		document.title = STRINGS.WIFI_TRANS_TITLE;
+		$('#login .content_title').text(STRINGS.LOGIN_TITLE);
+		$('#login_name').attr('placeholder', STRINGS.LOGIN_NAME);
+		$('#login_button').val(STRINGS.LOGIN_BUTTON).click(requestCode);
+		$('#login_name').keydown(function (evt) {
+			if (evt.keyCode == 13) {
+				requestCode();
+			}
+		});
+	});
+});
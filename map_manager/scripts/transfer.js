@@ -11,6 +11,8 @@ $(function () {
 
 	var html5Uploader = null;
 	var items = {};
+	var csrfToken = "";
+	var maxUploadSize = 1024 * 1024;
 
 	function initPageStrings() {
 		document.title = STRINGS.WIFI_TRANS_TITLE;
@@ -49,14 +51,97 @@ $(function () {
 		window.location = url;
 	}
 
+	function showPreview() {
+		var fileName = $(this).find('.filename').text();
+		var suffixIndex = fileName.lastIndexOf(".");
+		if (suffixIndex < 0) {
+			return;
+		}
+		var url = "thumbs/" + encodeURI(fileName.substring(0, suffixIndex)) + ".png";
+		$('#preview').empty()
+			.append($('<img />').attr('src', url).attr('title', fileName))
+			.show();
+	}
+
+	function formatTime(value) {
+		var d = new Date(value);
+		return d.getFullYear() + "-" + (d.getMonth() + 1) + "-" + d.getDate() + " " + d.getHours() + ":" + ("0" + d.getMinutes()).slice(-2);
+	}
+
+	function showVersions(title, versions, restore) {
+		var container = $('#versions').empty().show();
+		$('<div class="versions_title"></div>').text(title).appendTo(container);
+		if (versions.length == 0) {
+			$('<div class="version"></div>').text(STRINGS.NO_VERSIONS).appendTo(container);
+		}
+		$.each(versions, function (i, version) {
+			var row = $('<div class="version"></div>')
+				.text(version.label)
+				.appendTo(container);
+			if (version.restorable) {
+				$('<a href="javascript:void(0)"></a>')
+					.text(STRINGS.RESTORE)
+					.click(function () {
+						if (confirm(STRINGS.CONFIRM_RESTORE)) {
+							restore(version);
+						}
+					})
+					.appendTo(row);
+			}
+		});
+	}
+
+	function showHistory() {
+		var fileName = $(this).text();
+		$.getJSON("files/" + encodeURIComponent(fileName) + "/history?" + new Date().getTime(), function (data) {
+			var versions = [];
+			var current = data.length > 0 ? data[data.length - 1].hash : "";
+			for (var i = data.length - 1; i >= 0; i--) {
+				versions.push({
+					hash: data[i].hash,
+					label: formatTime(data[i].time) + " " + (data[i].user || "-") + " " + data[i].action + " " + formatFileSize(data[i].size),
+					restorable: data[i].hash != current
+				});
+			}
+			showVersions(fileName, versions, function (version) {
+				$.post("files/" + encodeURIComponent(fileName) + "/restore", { version: version.hash }, function () {
+					loadFileList();
+					$('#versions').hide();
+				});
+			});
+		});
+	}
+
+	function showTrash() {
+		$.getJSON("trash/?" + new Date().getTime(), function (data) {
+			var versions = [];
+			$.each(data, function (i, item) {
+				versions.push({
+					name: item.name,
+					label: item.name + " " + formatTime(item.deletedAt) + " " + (item.user || "-"),
+					restorable: true
+				});
+			});
+			showVersions(STRINGS.TRASH, versions, function (version) {
+				$.post("trash/" + encodeURIComponent(version.name) + "/restore", function () {
+					loadFileList();
+					showTrash();
+				});
+			});
+		});
+	}
+
 	function loadFileList() {
 		var now = new Date();
 		var url = "files?";
-		$.getJSON(url + now.getTime(), function (data) {
+		$.getJSON(url + now.getTime(), function (data, status, request) {
+			maxUploadSize = parseInt(request.getResponseHeader("X-Max-Upload-Size")) || maxUploadSize;
 			files = data;
 			fillFilesContainer();
 			$(".download").click(downloadBook);
 			$(".trash").click(deleteBook);
+			$("#right .file").mouseover(showPreview);
+			$("#right .files .filename").click(showHistory);
 		});
 	}
 
@@ -131,6 +216,7 @@ $(function () {
 			url: 'files/',
 			secureuri: false,
 			fileElementId: eleFileId,
+			data: { csrf_token: csrfToken },
 			dataType: 'text',
 			success: function (data, status) {
 				row.removeClass('progress_wrapper');
@@ -163,7 +249,7 @@ $(function () {
 	function checkFileName(fileName) {
         var suffixIndex=fileName.lastIndexOf(".");  
         var suffix=fileName.substring(suffixIndex+1).toUpperCase();  
-        if(suffix!="MMAP"){  
+        if(suffix!="MSAV"){  
             return STRINGS.UNSUPPORTED_FILE_TYPE;
 		}
 
@@ -183,7 +269,7 @@ $(function () {
 		return null;
 	}
 	function checkFileSize(files) {
-		var maxsize = 1 * 1024 * 1024;//1M
+		var maxsize = maxUploadSize;
 		
 		var filesize = 0;
 		if (!$.browser.msie) {
@@ -194,7 +280,7 @@ $(function () {
 		if (filesize == -1) {
 			return STRINGS.UPLOAD_FAILED;
 		} else if (filesize > maxsize) {
-			return STRINGS.EXCEEDS_FILE_SIZE;
+			return STRINGS.EXCEEDS_FILE_SIZE + Math.floor(maxsize / 1024) + "KB";
 		} else {
 			return null;
 		}
@@ -308,6 +394,7 @@ $(function () {
 				url: 'files/',
 				maxconnections: 1,
 				fieldname: 'newfile',
+				default_params: { csrf_token: csrfToken },
 				enqueued: function (item) {
 					var fileName = item.getFilename();
 					items[escape(fileName)] = item;
@@ -424,10 +511,46 @@ $(function () {
 		$('<div class="button_lable">' + STRINGS.SELECT_BUTTON_LABLE + '</div>').prependTo("#upload_button")
 	}
 
+	function logout() {
+		$.ajax({
+			type: "POST",
+			url: "auth/logout",
+			complete: function () {
+				window.location = "login.html";
+			}
+		});
+	}
+
+	function initSession() {
+		$.getJSON("auth/status?" + new Date().getTime(), function (data) {
+			if (!data.loggedIn) {
+				window.location = "login.html";
+				return;
+			}
+			csrfToken = data.csrf;
+			$.ajaxSetup({ headers: { "X-CSRF-Token": csrfToken } });
+			$('<a href="javascript:void(0)" id="trash_link"></a>')
+				.text(STRINGS.TRASH)
+				.click(showTrash)
+				.insertBefore('#preview');
+			$('<a href="dashboard.html" id="dashboard_link"></a>')
+				.text(STRINGS.DASHBOARD_TITLE)
+				.appendTo('#copyright');
+			$('<a href="console.html" id="console_link"></a>')
+				.text(STRINGS.CONSOLE_TITLE)
+				.appendTo('#copyright');
+			$('<a href="javascript:void(0)" id="logout"></a>')
+				.text(data.userName + " " + STRINGS.LOGOUT)
+				.click(logout)
+				.appendTo('#copyright');
+			loadFileList();
+		});
+	}
+
 	$(document).ready(function () {
 		initPageStrings();
 		fillFilesContainer();
-		loadFileList();
+		initSession();
 		$(window).resize(function () {
 			fillFilesContainer();
 		});
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MEMINFO_PATH = "/proc/meminfo"
+
+// Metrics holds the counters of an instance that are not already part of
+// the Mindustry state, /metrics writes them out in the Prometheus text format
+// with the server as label.
+type Metrics struct {
+	lock        sync.Mutex
+	cmds        map[[2]string]int64 //cmd name, result
+	votes       map[[2]string]int64 //cmd name, result
+	saveStart   time.Time
+	saveCount   int64
+	saveSeconds float64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		cmds:  make(map[[2]string]int64),
+		votes: make(map[[2]string]int64),
+	}
+}
+
+// UploadMetrics counts the bytes uploaded through the map manager. Maps are
+// shared by the instances, so it is kept for the process like the host
+// metrics.
+type UploadMetrics struct {
+	lock  sync.Mutex
+	bytes map[string]int64 //kind -> bytes
+}
+
+var uploadMetrics = &UploadMetrics{bytes: make(map[string]int64)}
+
+func (this *UploadMetrics) add(kind string, n int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.bytes[kind] += n
+}
+
+func (this *Metrics) incCmd(cmdName string, result string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.cmds[[2]string{cmdName, result}]++
+}
+
+func (this *Metrics) incVote(cmdName string, result string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.votes[[2]string{cmdName, result}]++
+}
+
+// saveStarted and saveFinished time a save from the command to the server's
+// "Saved to slot" line.
+func (this *Metrics) saveStarted() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.saveStart = time.Now()
+}
+
+func (this *Metrics) saveFinished() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.saveStart.IsZero() {
+		return
+	}
+	this.saveCount++
+	this.saveSeconds += time.Since(this.saveStart).Seconds()
+	this.saveStart = time.Time{}
+}
+
+func cmdResult(err error) string {
+	switch err {
+	case nil:
+		return "ok"
+	case errCmdInvalid:
+		return "invalid"
+	case errCmdPermissionDenied:
+		return "permission_denied"
+	case errCmdExecuting:
+		return "executing"
+	}
+	return "failed"
+}
+
+// getHostMemory returns MemTotal and MemAvailable in bytes.
+func getHostMemory() (int64, int64, error) {
+	f, err := os.Open(MEMINFO_PATH)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	var total, available int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		temps := strings.Fields(scanner.Text())
+		if len(temps) < 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(temps[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch temps[0] {
+		case "MemTotal:":
+			total = value * 1024
+		case "MemAvailable:":
+			available = value * 1024
+		}
+	}
+	return total, available, scanner.Err()
+}
+
+func writeMetric(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeLabeled(w io.Writer, name string, server string, values map[[2]string]int64, label1 string, label2 string) {
+	keys := make([][2]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{server=%q,%s=%q,%s=%q} %d\n", name, server, label1, key[0], label2, key[1], values[key])
+	}
+}
+
+// metricsHandler serves /metrics, it sits behind WebAuth.protect so
+// Prometheus scrapes it with one of the apiTokens as bearer token.
+type metricsHandler struct {
+	mindustry *Mindustry
+}
+
+func (this *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := this.mindustry
+	m.lock.Lock()
+	server := m.serverName()
+	playCnt, serverIsRun, restarts, crashes := m.onlineCount(), m.serverIsRun, m.restarts, m.crashes
+	m.lock.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "mindustry_players_online", "gauge", "Players online.")
+	fmt.Fprintf(w, "mindustry_players_online{server=%q} %d\n", server, playCnt)
+	writeMetric(w, "mindustry_server_up", "gauge", "1 if the game server is running.")
+	fmt.Fprintf(w, "mindustry_server_up{server=%q} %d\n", server, boolValue(serverIsRun))
+	writeMetric(w, "mindustry_server_restarts_total", "counter", "Times the JVM was started again.")
+	fmt.Fprintf(w, "mindustry_server_restarts_total{server=%q} %d\n", server, restarts)
+	writeMetric(w, "mindustry_server_crashes_total", "counter", "Times the JVM exited without a restart command.")
+	fmt.Fprintf(w, "mindustry_server_crashes_total{server=%q} %d\n", server, crashes)
+	writeMetric(w, "mindustry_cpu_temperature_celsius", "gauge", "Host CPU temperature.")
+	fmt.Fprintf(w, "mindustry_cpu_temperature_celsius %.3f\n", getCpuTemp())
+	if total, available, err := getHostMemory(); err == nil {
+		writeMetric(w, "mindustry_host_memory_total_bytes", "gauge", "Host memory.")
+		fmt.Fprintf(w, "mindustry_host_memory_total_bytes %d\n", total)
+		writeMetric(w, "mindustry_host_memory_available_bytes", "gauge", "Host memory available.")
+		fmt.Fprintf(w, "mindustry_host_memory_available_bytes %d\n", available)
+	}
+	metrics := m.metrics
+	metrics.lock.Lock()
+	writeMetric(w, "mindustry_commands_total", "counter", "Chat and web commands by name and result.")
+	writeLabeled(w, "mindustry_commands_total", server, metrics.cmds, "cmd", "result")
+	writeMetric(w, "mindustry_votes_total", "counter", "Finished votes by command and result.")
+	writeLabeled(w, "mindustry_votes_total", server, metrics.votes, "cmd", "result")
+	writeMetric(w, "mindustry_save_duration_seconds", "summary", "Time the server took to write a save.")
+	fmt.Fprintf(w, "mindustry_save_duration_seconds_sum{server=%q} %.3f\n", server, metrics.saveSeconds)
+	fmt.Fprintf(w, "mindustry_save_duration_seconds_count{server=%q} %d\n", server, metrics.saveCount)
+	metrics.lock.Unlock()
+	uploadMetrics.lock.Lock()
+	defer uploadMetrics.lock.Unlock()
+	writeMetric(w, "mindustry_upload_bytes_total", "counter", "Bytes of maps and saves uploaded through the map manager.")
+	kinds := make([]string, 0, len(uploadMetrics.bytes))
+	for kind := range uploadMetrics.bytes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "mindustry_upload_bytes_total{kind=%q} %d\n", kind, uploadMetrics.bytes[kind])
+	}
+}
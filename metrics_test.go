@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsPerServer(t *testing.T) {
+	servers := &Servers{}
+	for _, id := range []string{"a", "b"} {
+		servers.list = append(servers.list, &Mindustry{servers: servers, id: id, online: make(map[string]OnlinePlayer), metrics: newMetrics()})
+	}
+	servers.list[0].metrics.incCmd("kick", "ok")
+	servers.list[0].online["p"] = OnlinePlayer{Name: "p"}
+	servers.list[1].restarts = 2
+	tests := []struct {
+		server string
+		want   []string
+		absent []string
+	}{
+		{"a", []string{`mindustry_commands_total{server="a",cmd="kick",result="ok"} 1`, `mindustry_players_online{server="a"} 1`, `mindustry_server_restarts_total{server="a"} 0`},
+			[]string{`server="b"`}},
+		{"b", []string{`mindustry_players_online{server="b"} 0`, `mindustry_server_restarts_total{server="b"} 2`},
+			[]string{`server="a"`, `cmd="kick"`}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		(&metricsHandler{servers.get(test.server)}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		body := w.Body.String()
+		for _, line := range test.want {
+			if !strings.Contains(body, line+"\n") {
+				t.Errorf("%s: %s missing", test.server, line)
+			}
+		}
+		for _, s := range test.absent {
+			if strings.Contains(body, s) {
+				t.Errorf("%s: %s found", test.server, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PLAYER_LANG_FILE keeps the language every player chose with \lang.
+const PLAYER_LANG_FILE = "./config/player_lang.json"
+
+// PlayerLangs is shared by the servers of this process.
+type PlayerLangs struct {
+	lock  sync.Mutex
+	file  string
+	langs map[string]string //player name -> locale
+}
+
+func loadPlayerLangs(file string) *PlayerLangs {
+	langs := &PlayerLangs{file: file, langs: make(map[string]string)}
+	if data, err := ioutil.ReadFile(file); err == nil {
+		if err = json.Unmarshal(data, &langs.langs); err != nil {
+			supervisorLog.warnf("[lang]load %s fail:%v", file, err)
+		}
+	}
+	return langs
+}
+
+func (this *PlayerLangs) get(name string) string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.langs[name]
+}
+
+// set saves the language of a player, "" goes back to the server language.
+func (this *PlayerLangs) set(name string, lang string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if lang == "" {
+		delete(this.langs, name)
+	} else {
+		this.langs[name] = lang
+	}
+	return writeJsonFile(this.file, this.langs)
+}
+
+// translations returns the language a player chose, the server language for
+// everyone else.
+func (this *Mindustry) translations(userName string) *Locale {
+	if this.servers == nil || this.servers.langs == nil {
+		return this.i18n
+	}
+	if lang := this.servers.langs.get(userName); lang != "" {
+		return this.locales.get(lang)
+	}
+	return this.i18n
+}
+
+// tell sends a message to one player. With whisperCmd set an online player
+// gets it privately in the player's language, otherwise everyone sees it in
+// the server language.
+func (this *Mindustry) tell(in io.WriteCloser, userName string, strKey string, v ...interface{}) {
+	if _, ok := this.online[userName]; ok && this.whisperCmd != "" {
+		info := fmt.Sprintf(this.translations(userName).Value(strKey), v...)
+		in.Write([]byte(this.whisperCmd + " " + whisperName(userName) + " " + info + "\n"))
+		return
+	}
+	in.Write([]byte("say " + fmt.Sprintf(this.i18n.Value(strKey), v...) + "\n"))
+}
+
+// whisperName quotes a name with spaces or quotes so that whisperCmd takes
+// it as one argument.
+func whisperName(userName string) string {
+	if strings.ContainsAny(userName, " \t\"\\") {
+		return strconv.Quote(userName)
+	}
+	return userName
+}
+
+func (this *Mindustry) proc_lang(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	lang := strings.TrimSpace(userInput[len("lang"):])
+	if lang != "" && lang != "default" && !inList(this.locales.names(), lang) {
+		this.say(in, "error.cmd_lang_invalid", lang, strings.Join(this.locales.names(), ","))
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	if lang == "" {
+		current := this.servers.langs.get(userName)
+		if current == "" {
+			current = this.config.language
+		}
+		this.say(in, "info.lang_current", current, strings.Join(this.locales.names(), ","))
+		return true
+	}
+	if lang == "default" {
+		lang = ""
+	}
+	if err := this.servers.langs.set(userName, lang); err != nil {
+		commandsLog.with(this.id).warnf("[lang]save %s fail:%v", userName, err)
+	}
+	if lang == "" {
+		lang = this.config.language
+	}
+	this.say(in, "info.lang_set", lang)
+	if this.whisperCmd == "" {
+		this.say(in, "info.lang_no_whisper", this.config.language)
+	}
+	return true
+}
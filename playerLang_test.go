@@ -0,0 +1,77 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTell(t *testing.T) {
+	enUS := &Locale{name: "en_US", messages: map[string]string{"info.hi": "hi %s"}}
+	zhCN := &Locale{name: "zh_CN", messages: map[string]string{"info.hi": "你好 %s"}, fallback: enUS}
+	servers := &Servers{langs: loadPlayerLangs(filepath.Join(t.TempDir(), "langs.json"))}
+	for _, name := range []string{"zh", "Mr Space", `a"b`} {
+		servers.langs.set(name, "zh_CN")
+	}
+	tests := []struct {
+		whisperCmd string
+		userName   string
+		online     bool
+		want       string
+	}{
+		{"pm", "zh", true, "pm zh 你好 x"},
+		{"pm", "Mr Space", true, `pm "Mr Space" 你好 x`},
+		{"pm", `a"b`, true, `pm "a\"b" 你好 x`},
+		{"pm", "zh", false, "say hi x"},
+		{"", "zh", true, "say hi x"},
+	}
+	for _, test := range tests {
+		in := &recordWriter{}
+		m := &Mindustry{servers: servers, whisperCmd: test.whisperCmd, i18n: enUS,
+			locales: Locales{"en_US": enUS, "zh_CN": zhCN}, online: make(map[string]OnlinePlayer)}
+		if test.online {
+			m.online[test.userName] = OnlinePlayer{Name: test.userName}
+		}
+		m.tell(in, test.userName, "info.hi", "x")
+		if sent := in.sent(); len(sent) != 1 || sent[0] != test.want {
+			t.Errorf("tell %s whisperCmd %q online %v sent %q, want %q", test.userName, test.whisperCmd, test.online, sent, test.want)
+		}
+	}
+}
+
+func TestLangWithoutWhisper(t *testing.T) {
+	for _, whisperCmd := range []string{"", "pm"} {
+		in := &recordWriter{}
+		m := newTestMindustry(t, testIni+"whisperCmd="+whisperCmd+"\n", in)
+		m.online["admin"] = OnlinePlayer{Name: "admin"}
+		m.lock.Lock()
+		m.procUsrCmd(in, "admin", "lang default", AUDIT_CHAT)
+		m.lock.Unlock()
+		warned := false
+		for _, line := range in.sent() {
+			warned = warned || strings.Contains(line, "info.lang_no_whisper")
+		}
+		if warned != (whisperCmd == "") {
+			t.Errorf("whisperCmd %q: sent %q", whisperCmd, in.sent())
+		}
+	}
+}
+
+func TestAnswerLaterTold(t *testing.T) {
+	in := &recordWriter{}
+	m := newTestMindustry(t, testIni+"whisperCmd=pm\n", in)
+	m.online["admin"] = OnlinePlayer{Name: "admin"}
+	for _, line := range []string{"[INFO] admin: \\maps", "[INFO] Maps:", "[INFO]   Ancient: Custom / 100x100", "[INFO] Map directory: config/maps"} {
+		m.lock.Lock()
+		m.output(line, in)
+		m.lock.Unlock()
+	}
+	for i := 0; i < 100; i++ {
+		if sent := in.sent(); len(sent) > 0 && strings.HasPrefix(sent[len(sent)-1], "pm admin info.maps_list") {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("maps answer not told to admin: %q", in.sent())
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Newer servers append the player's uuid to the join/leave lines, eg
+// "Anuke has connected. [AAAAAAAAAAA=]", older ones only print the name.
+var connectedR = regexp.MustCompile("^(.+?) has connected\\.(?: \\[([^\\]]+)\\])?$")
+var disconnectedR = regexp.MustCompile("^(.+?) has disconnected\\.(?: \\[([^\\]]+)\\])?")
+
+// statusPlayerR matches the player lines that follow "Players: n" in the
+// status output, eg "Anuke / AAAAAAAAAAA=".
+var statusPlayerR = regexp.MustCompile("^(.+) / ([A-Za-z0-9+/]{8,}={0,2})$")
+
+// statusLineR matches the other lines of the status output.
+var statusLineR = regexp.MustCompile("(?i)^(Status|Playing on map .+ / wave \\d+|\\d+ enemies|\\d+ seconds until next wave|\\d+ FPS|Players: *\\d+$|No players connected)")
+
+type OnlinePlayer struct {
+	Name     string    `json:"name"`
+	Uuid     string    `json:"uuid"`
+	JoinTime time.Time `json:"joinTime"`
+}
+
+// onlineCount is the number every vote threshold is computed against.
+func (this *Mindustry) onlineCount() int {
+	return len(this.online)
+}
+
+func (this *Mindustry) clearOnline() {
+	this.online = make(map[string]OnlinePlayer)
+	this.leaveTime = make(map[string]time.Time)
+}
+
+// statusSpec ends the status answer after the player list, or at the
+// first line when the server is closed. Player lines are only taken while
+// the list is read, other output goes on to output().
+func statusSpec() CmdSpec {
+	playerCnt, readCnt := -1, 0
+	return CmdSpec{match: func(line string) bool {
+		return statusLineR.MatchString(line) || (readCnt < playerCnt && statusPlayerR.MatchString(line))
+	}, done: func(line string) bool {
+		if strings.Index(line, "Status: server closed") >= 0 || strings.Index(line, "No players connected.") >= 0 {
+			return true
+		}
+		if index := strings.Index(line, "Players:"); index >= 0 {
+			if count, err := strconv.Atoi(strings.TrimSpace(line[index+len("Players:"):])); err == nil {
+				playerCnt, readCnt = count, 0
+			}
+			return playerCnt == 0
+		}
+		if playerCnt > 0 && statusPlayerR.MatchString(line) {
+			readCnt++
+			return readCnt >= playerCnt
+		}
+		return false
+	}}
+}
+
+// queryStatus asks the server for its status, the player list in the answer
+// replaces the online set built from the join/leave lines.
+func (this *Mindustry) queryStatus() *CmdFuture {
+	sendTime := time.Now()
+	future := this.executor.send("status", statusSpec())
+	future.then(func(lines []string, err error) {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		this.applyStatus(lines, err, sendTime)
+	})
+	return future
+}
+
+// reconcileTask keeps the online set in line with the server between the ten
+// minute status queries.
+func (this *Mindustry) reconcileTask() {
+	if !this.serverIsRun {
+		return
+	}
+	this.queryStatus()
+}
+
+func (this *Mindustry) applyStatus(lines []string, err error, sendTime time.Time) {
+	players := []OnlinePlayer{}
+	playerCnt := -1
+	for _, line := range lines {
+		this.trackStatus(line)
+		if strings.Index(line, "Status: server closed") >= 0 {
+			this.serverIsRun = false
+			this.clearOnline()
+			return
+		}
+		if strings.Index(line, "No players connected.") >= 0 {
+			playerCnt = 0
+		} else if index := strings.Index(line, "Players:"); index >= 0 {
+			if count, err := strconv.Atoi(strings.TrimSpace(line[index+len("Players:"):])); err == nil {
+				playerCnt = count
+				players = players[0:0]
+			}
+		} else if m := statusPlayerR.FindStringSubmatch(line); m != nil && playerCnt > 0 {
+			players = append(players, OnlinePlayer{Name: strings.TrimSpace(m[1]), Uuid: m[2]})
+		}
+	}
+	if err != nil || playerCnt < 0 || len(players) != playerCnt {
+		parserLog.with(this.id).warnf("[players]incomplete status answer:%v", err)
+		return
+	}
+	this.reconcileOnline(players, sendTime)
+}
+
+// reconcileOnline replaces the online set with the players of a status
+// answer, players that were already known keep their join time, players
+// that joined after the status was sent are kept and players that left
+// after it was sent are not added again.
+func (this *Mindustry) reconcileOnline(players []OnlinePlayer, sendTime time.Time) {
+	online := make(map[string]OnlinePlayer)
+	for _, player := range players {
+		if this.leaveTime[player.Name].After(sendTime) {
+			continue
+		}
+		if known, ok := this.online[player.Name]; ok {
+			player.JoinTime = known.JoinTime
+			if player.Uuid == "" {
+				player.Uuid = known.Uuid
+			}
+		} else {
+			player.JoinTime = time.Now()
+			this.addUser(player.Name)
+		}
+		online[player.Name] = player
+	}
+	for name, player := range this.online {
+		if _, ok := online[name]; !ok && player.JoinTime.Before(sendTime) {
+			parserLog.with(this.id).infof("[players]%s is not online any more", name)
+			this.offlineUser(name)
+		}
+	}
+	for name, player := range online {
+		if _, ok := this.online[name]; !ok {
+			parserLog.with(this.id).infof("[players]%s is online", name)
+		}
+		this.online[name] = player
+	}
+	//the next status is sent after this answer, older leaves do not matter
+	for name, leaveTime := range this.leaveTime {
+		if !leaveTime.After(sendTime) {
+			delete(this.leaveTime, name)
+		}
+	}
+}
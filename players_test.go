@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconcileOnline(t *testing.T) {
+	sendTime := time.Now()
+	before, after := sendTime.Add(-time.Minute), sendTime.Add(time.Second)
+	tests := []struct {
+		name      string
+		online    map[string]time.Time //join time
+		leaveTime map[string]time.Time
+		status    []string
+		want      []string
+	}{
+		{"listed", map[string]time.Time{"a": before}, nil, []string{"a", "b"}, []string{"a", "b"}},
+		{"gone", map[string]time.Time{"a": before}, nil, nil, nil},
+		{"joined after send", map[string]time.Time{"a": after}, nil, nil, []string{"a"}},
+		{"left after send", nil, map[string]time.Time{"a": after}, []string{"a", "b"}, []string{"b"}},
+		{"left before send", nil, map[string]time.Time{"a": before}, []string{"a"}, []string{"a"}},
+		{"left and joined after send", map[string]time.Time{"a": after}, map[string]time.Time{"a": after}, []string{"a"}, []string{"a"}},
+	}
+	for _, test := range tests {
+		m := &Mindustry{online: make(map[string]OnlinePlayer), leaveTime: make(map[string]time.Time), users: make(map[string]User)}
+		for name, joinTime := range test.online {
+			m.online[name] = OnlinePlayer{Name: name, JoinTime: joinTime}
+		}
+		for name, leaveTime := range test.leaveTime {
+			m.leaveTime[name] = leaveTime
+		}
+		players := []OnlinePlayer{}
+		for _, name := range test.status {
+			players = append(players, OnlinePlayer{Name: name, Uuid: "AAAAAAAAAAA="})
+		}
+		m.reconcileOnline(players, sendTime)
+		if len(m.online) != len(test.want) {
+			t.Errorf("%s: online = %v, want %v", test.name, m.online, test.want)
+			continue
+		}
+		for _, name := range test.want {
+			if _, ok := m.online[name]; !ok {
+				t.Errorf("%s: %s not online", test.name, name)
+			}
+		}
+		for name, leaveTime := range m.leaveTime {
+			if !leaveTime.After(sendTime) {
+				t.Errorf("%s: leave of %s kept", test.name, name)
+			}
+		}
+	}
+}
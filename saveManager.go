@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SAVE_PATH = "./config/saves/"
+const SAVE_EXT = ".msav"
+const UPLOAD_SLOT_BASE = 1000
+
+var slotNameR = regexp.MustCompile("^[0-9A-Za-z_-]+$")
+
+type SaveDesc struct {
+	Slot     string    `json:"slot"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	MapName  string    `json:"mapName"`
+	Wave     string    `json:"wave"`
+	PlayTime string    `json:"playTime"`
+	Build    string    `json:"build"`
+	Version  int       `json:"version"`
+}
+
+// saveHandler serves the saves of a server to web admins, it is only reachable
+// through WebAuth.protect. Loading a slot needs the same level as \load.
+type saveHandler struct {
+	mindustry *Mindustry
+}
+
+// savePath is the saves directory of the server, every instance has its own.
+func (this *Mindustry) savePath() string {
+	if this.dir == "" || this.dir == "." {
+		return SAVE_PATH
+	}
+	return filepath.Join(this.dir, "config", "saves") + "/"
+}
+
+func slotFileName(savePath string, slot string) string {
+	return savePath + slot + SAVE_EXT
+}
+
+func getSaveList(savePath string) ([]SaveDesc, error) {
+	files, err := ioutil.ReadDir(savePath)
+	if err != nil {
+		return nil, err
+	}
+	saves := []SaveDesc{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), SAVE_EXT) {
+			continue
+		}
+		desc := SaveDesc{
+			Slot:    strings.TrimSuffix(f.Name(), SAVE_EXT),
+			Size:    f.Size(),
+			ModTime: f.ModTime(),
+		}
+		if info, err := readMsav(savePath+f.Name(), false); err == nil {
+			desc.MapName = info.Tags["mapname"]
+			desc.Wave = info.Tags["wave"]
+			desc.PlayTime = info.Tags["playtime"]
+			desc.Build = info.Tags["build"]
+			desc.Version = info.Version
+		} else {
+			httpLog.warnf("[saves]read %s meta fail:%v", f.Name(), err)
+		}
+		saves = append(saves, desc)
+	}
+	sort.Slice(saves, func(i, j int) bool {
+		return saves[i].ModTime.After(saves[j].ModTime)
+	})
+	return saves, nil
+}
+
+func nextFreeSlot(savePath string) string {
+	for slot := UPLOAD_SLOT_BASE; ; slot++ {
+		if exist, _ := exists(slotFileName(savePath, strconv.Itoa(slot))); !exist {
+			return strconv.Itoa(slot)
+		}
+	}
+}
+
+func (this *saveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userName := webUser(r)
+	//path layout: /saves/ , /saves/<slot> , /saves/<slot>/load
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/saves"), "/"), "/")
+	slot := strings.TrimSuffix(parts[0], SAVE_EXT)
+	if slot != "" && !slotNameR.MatchString(slot) {
+		http.Error(w, "invalid slot", http.StatusBadRequest)
+		return
+	}
+	//protect lets in the admins of any server, changing the saves of this
+	//one needs an admin here
+	if r.Method != "GET" && r.Method != "HEAD" && !this.mindustry.isAdmin(userName) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	var err error
+	switch {
+	case r.Method == "GET" && slot == "":
+		err = this.handleList(w, r)
+	case r.Method == "GET" && len(parts) == 1:
+		err = this.handleDownload(w, r, slot)
+	case r.Method == "POST" && slot == "":
+		err = this.handleUpload(w, r, userName)
+	case r.Method == "POST" && len(parts) == 2 && parts[1] == "load":
+		err = this.handleLoad(w, r, userName, slot)
+	case r.Method == "DELETE" && len(parts) == 1 && slot != "":
+		err = this.handleDelete(w, r, userName, slot)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (this *saveHandler) handleList(w http.ResponseWriter, r *http.Request) error {
+	saves, err := getSaveList(this.mindustry.savePath())
+	if err != nil {
+		return err
+	}
+	output, err := json.MarshalIndent(&saves, "", "\t\t")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(output)
+	return nil
+}
+
+func (this *saveHandler) handleDownload(w http.ResponseWriter, r *http.Request, slot string) error {
+	file := slotFileName(this.mindustry.savePath(), slot)
+	if exist, _ := exists(file); !exist {
+		http.NotFound(w, r)
+		return nil
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+slot+SAVE_EXT+"\"")
+	http.ServeFile(w, r, file)
+	return nil
+}
+
+func (this *saveHandler) handleUpload(w http.ResponseWriter, r *http.Request, userName string) error {
+	tmpFile, _, status, err := receiveUpload(w, r, this.mindustry.savePath())
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return nil
+	}
+	slot := strings.TrimSpace(r.FormValue("slot"))
+	if slot == "" {
+		slot = nextFreeSlot(this.mindustry.savePath())
+	} else if !slotNameR.MatchString(slot) {
+		os.Remove(tmpFile)
+		http.Error(w, "invalid slot", http.StatusBadRequest)
+		return nil
+	}
+	target := slotFileName(this.mindustry.savePath(), slot)
+	if exist, _ := exists(target); exist {
+		os.Remove(tmpFile)
+		http.Error(w, "slot "+slot+" already exists", http.StatusConflict)
+		return nil
+	}
+	if err = os.Rename(tmpFile, target); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	httpLog.infof("[saves]%s upload slot %s", userName, slot)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "{\"slot\":%q}", slot)
+	return nil
+}
+
+func (this *saveHandler) handleDelete(w http.ResponseWriter, r *http.Request, userName string, slot string) error {
+	err := os.Remove(slotFileName(this.mindustry.savePath(), slot))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	httpLog.infof("[saves]%s delete slot %s", userName, slot)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (this *saveHandler) handleLoad(w http.ResponseWriter, r *http.Request, userName string, slot string) error {
+	if exist, _ := exists(slotFileName(this.mindustry.savePath(), slot)); !exist {
+		http.NotFound(w, r)
+		return nil
+	}
+	if err := this.mindustry.webLoadSlot(userName, slot); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil
+	}
+	httpLog.infof("[saves]%s load slot %s", userName, slot)
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// webLoadSlot runs \load for a web admin, the restart runs as a job exactly
+// as for the chat command.
+func (this *Mindustry) webLoadSlot(userName string, slot string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.in == nil {
+		return errors.New("server is not running")
+	}
+	if cmd, ok := this.cmds["load"]; !ok || this.users[userName].level < cmd.level {
+		return errors.New("permission denied")
+	}
+	handleFunc := this.userCmdProcHandles["load"]
+	userInput := "load " + slot
+	if this.job != nil {
+		return errors.New("job " + this.job.Name + " is running")
+	}
+	if !handleFunc(this.in, userName, userInput, true) || !handleFunc(this.in, userName, userInput, false) {
+		return errors.New("load slot " + slot + " rejected")
+	}
+	return nil
+}
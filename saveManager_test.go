@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveHandlerAdmin(t *testing.T) {
+	servers := newTestServers(t)
+	other := &Mindustry{servers: servers, id: "other", dir: t.TempDir(), online: make(map[string]OnlinePlayer),
+		users: map[string]User{"otherAdmin": {"otherAdmin", true, false, 1}}}
+	servers.list = append(servers.list, other)
+	os.MkdirAll(other.savePath(), 0777)
+	tests := []struct {
+		method, path, userName string
+		status                 int
+	}{
+		{"GET", "/saves/", "admin", 200},
+		{"DELETE", "/saves/1", "admin", 403},
+		{"POST", "/saves/1/load", "admin", 403},
+		{"POST", "/saves/", "admin", 403},
+		{"DELETE", "/saves/1", "otherAdmin", 200},
+	}
+	for _, test := range tests {
+		ioutil.WriteFile(filepath.Join(other.savePath(), "1"+SAVE_EXT), []byte("save"), 0666)
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), webUserKey{}, test.userName))
+		w := httptest.NewRecorder()
+		(&saveHandler{other}).ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s as %s = %d, want %d", test.method, test.path, test.userName, w.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const SERVER_CMD_TIMEOUT = 5 * time.Second
+
+var errCmdTimeout = errors.New("command timeout")
+
+// CmdSpec describes the answer of a console command: the lines that belong
+// to it and the line that ends it.
+type CmdSpec struct {
+	match   func(line string) bool //nil: every line until done
+	done    func(line string) bool
+	timeout time.Duration
+}
+
+// CmdFuture collects the answer of one console command.
+type CmdFuture struct {
+	cmd      string
+	spec     CmdSpec
+	lines    []string
+	err      error
+	finished chan struct{}
+}
+
+func (this *CmdFuture) finish(err error) {
+	this.err = err
+	close(this.finished)
+}
+
+// wait blocks until the answer is complete or timed out, on timeout the
+// lines read so far are returned with errCmdTimeout.
+func (this *CmdFuture) wait() ([]string, error) {
+	<-this.finished
+	return this.lines, this.err
+}
+
+// then calls f in its own goroutine once the answer is complete, so it can
+// be used by code that holds the Mindustry lock.
+func (this *CmdFuture) then(f func(lines []string, err error)) {
+	go func() {
+		f(this.wait())
+	}()
+}
+
+// CmdExecutor writes commands to the server one at a time and hands the
+// output lines to the command that is waiting for them. Commands sent while
+// another one is running are queued.
+type CmdExecutor struct {
+	lock    sync.Mutex
+	in      io.WriteCloser
+	queue   []*CmdFuture
+	current *CmdFuture
+	timer   *time.Timer
+	closed  bool
+}
+
+func newCmdExecutor(in io.WriteCloser) *CmdExecutor {
+	return &CmdExecutor{in: in}
+}
+
+func (this *CmdExecutor) send(cmd string, spec CmdSpec) *CmdFuture {
+	if spec.timeout == 0 {
+		spec.timeout = SERVER_CMD_TIMEOUT
+	}
+	future := &CmdFuture{cmd: cmd, spec: spec, finished: make(chan struct{})}
+	if this == nil {
+		future.finish(errServerNotRunning)
+		return future
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		future.finish(errServerNotRunning)
+		return future
+	}
+	this.queue = append(this.queue, future)
+	if this.current == nil {
+		this.next()
+	}
+	return future
+}
+
+// next starts the first queued command, it runs with the lock held.
+func (this *CmdExecutor) next() {
+	this.current = nil
+	if len(this.queue) == 0 {
+		return
+	}
+	future := this.queue[0]
+	this.queue = this.queue[1:]
+	this.current = future
+	commandsLog.debugf("execCmd :%s", future.cmd)
+	if _, err := this.in.Write([]byte(future.cmd + "\n")); err != nil {
+		future.finish(err)
+		this.next()
+		return
+	}
+	this.timer = time.AfterFunc(future.spec.timeout, func() {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.current == future {
+			commandsLog.warnf("[cmd]%s timeout", future.cmd)
+			future.finish(errCmdTimeout)
+			this.next()
+		}
+	})
+}
+
+// feed offers an output line to the running command and returns true if the
+// line belongs to its answer.
+func (this *CmdExecutor) feed(line string) bool {
+	if this == nil {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	future := this.current
+	if future == nil {
+		return false
+	}
+	if future.spec.match != nil && !future.spec.match(line) {
+		return false
+	}
+	future.lines = append(future.lines, line)
+	if future.spec.done(line) {
+		this.timer.Stop()
+		future.finish(nil)
+		this.next()
+	}
+	return true
+}
+
+// close fails the running and queued commands, it is called when the server
+// exits.
+func (this *CmdExecutor) close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closed = true
+	if this.current != nil {
+		this.timer.Stop()
+		this.current.finish(errServerNotRunning)
+		this.current = nil
+	}
+	for _, future := range this.queue {
+		future.finish(errServerNotRunning)
+	}
+	this.queue = nil
+}
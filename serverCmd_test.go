@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCmdExecutorFeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    CmdSpec
+		lines   []string
+		claimed []bool
+		answer  []string
+	}{
+		{"status", statusSpec(),
+			[]string{"Status:", "Anuke: hi", "Playing on map Ancient Caldera / Wave 3", "12 enemies.", "Players: 2", "Anuke has connected.", "Anuke / AAAAAAAAAAA=", "Foo / BBBBBBBBBBB="},
+			[]bool{true, false, true, true, true, false, true, true},
+			[]string{"Status:", "Playing on map Ancient Caldera / Wave 3", "12 enemies.", "Players: 2", "Anuke / AAAAAAAAAAA=", "Foo / BBBBBBBBBBB="}},
+		{"status without players", statusSpec(),
+			[]string{"Status:", "Playing on map Frozen Forest / Wave 1", "No players connected."},
+			[]bool{true, true, true},
+			[]string{"Status:", "Playing on map Frozen Forest / Wave 1", "No players connected."}},
+		{"status of a closed server", statusSpec(),
+			[]string{"Status: server closed"},
+			[]bool{true},
+			[]string{"Status: server closed"}},
+		{"bans", bansSpec,
+			[]string{"Banned players [ID]:", "Server saved.", "AAAAAAAAAAA= / Last known name: 'Anuke'", "No IP-banned players."},
+			[]bool{true, false, true, true},
+			[]string{"Banned players [ID]:", "AAAAAAAAAAA= / Last known name: 'Anuke'", "No IP-banned players."}},
+	}
+	for _, test := range tests {
+		executor := newCmdExecutor(nopWriter{})
+		future := executor.send(test.name, test.spec)
+		for i, line := range test.lines {
+			if claimed := executor.feed(line); claimed != test.claimed[i] {
+				t.Errorf("%s: feed %q = %v, want %v", test.name, line, claimed, test.claimed[i])
+			}
+		}
+		lines, err := future.wait()
+		if err != nil || strings.Join(lines, "\n") != strings.Join(test.answer, "\n") {
+			t.Errorf("%s: answer %q %v, want %q", test.name, lines, err, test.answer)
+		}
+		if executor.feed("Status:") {
+			t.Errorf("%s: line claimed after the answer", test.name)
+		}
+	}
+}
+
+func TestCmdExecutorTimeout(t *testing.T) {
+	in := &recordWriter{}
+	executor := newCmdExecutor(in)
+	never := func(line string) bool { return false }
+	first := executor.send("first", CmdSpec{done: never, timeout: 20 * time.Millisecond})
+	second := executor.send("second", CmdSpec{done: func(line string) bool { return line == "end" }})
+	if sent := in.sent(); len(sent) != 1 || sent[0] != "first" {
+		t.Fatalf("sent %q before the first answer", sent)
+	}
+	executor.feed("partial")
+	lines, err := first.wait()
+	if err != errCmdTimeout || len(lines) != 1 || lines[0] != "partial" {
+		t.Fatalf("first = %q %v, want the partial answer and a timeout", lines, err)
+	}
+	if sent := in.sent(); len(sent) != 2 || sent[1] != "second" {
+		t.Fatalf("second not sent after the timeout:%q", sent)
+	}
+	executor.feed("end")
+	if lines, err = second.wait(); err != nil || len(lines) != 1 {
+		t.Fatalf("second = %q %v", lines, err)
+	}
+}
+
+func TestCmdExecutorClose(t *testing.T) {
+	executor := newCmdExecutor(nopWriter{})
+	never := func(line string) bool { return false }
+	running := executor.send("running", CmdSpec{done: never})
+	queued := executor.send("queued", CmdSpec{done: never})
+	executor.close()
+	after := executor.send("after", CmdSpec{done: never})
+	for name, future := range map[string]*CmdFuture{"running": running, "queued": queued, "after": after} {
+		if _, err := future.wait(); err != errServerNotRunning {
+			t.Errorf("%s: err = %v, want %v", name, err, errServerNotRunning)
+		}
+	}
+	var stopped *CmdExecutor
+	if _, err := stopped.send("status", statusSpec()).wait(); err != errServerNotRunning {
+		t.Errorf("nil executor: err = %v", err)
+	}
+	if _, err := newCmdExecutor(failWriter{}).send("status", statusSpec()).wait(); err == nil {
+		t.Error("write error not returned")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Servers are the server instances this process supervises, one per
+// [server:NAME] section or a single one without them. They share the http
+// server, the web logins and the maps of ./config/maps.
+type Servers struct {
+	list     []*Mindustry
+	lock     sync.Mutex   //taken after the lock of an instance, never before
+	remote   []ServerInfo //servers of the other processes of the cluster
+	bans     []Ban
+	langs    *PlayerLangs
+	chatLog  *ChatLog
+	auditLog *AuditLog
+}
+
+// ServerInfo is what \servers and /api/v1/servers show of an instance.
+type ServerInfo struct {
+	Name      string `json:"name"`
+	Title     string `json:"title"`
+	Host      string `json:"host,omitempty"`
+	Port      int    `json:"port"`
+	Mode      string `json:"mode"`
+	ServerRun bool   `json:"serverRun"`
+	Players   int    `json:"players"`
+	Map       string `json:"map"`
+}
+
+func newServers(instances []string, mode string, port int) *Servers {
+	servers := &Servers{langs: loadPlayerLangs(PLAYER_LANG_FILE), chatLog: newChatLog(CHAT_LOG_PATH),
+		auditLog: newAuditLog(AUDIT_LOG_FILE)}
+	for _, id := range instances {
+		mindustry := &Mindustry{id: id, servers: servers, mode: mode, port: port}
+		mindustry.init()
+		servers.list = append(servers.list, mindustry)
+	}
+	return servers
+}
+
+// get returns the instance with the name, "" is the first one.
+func (this *Servers) get(name string) *Mindustry {
+	if name == "" {
+		return this.list[0]
+	}
+	for _, mindustry := range this.list {
+		if mindustry.id == name {
+			return mindustry
+		}
+	}
+	return nil
+}
+
+// isAdmin is true for an admin of any instance, what the admin may do is
+// checked by the instance a request goes to.
+func (this *Servers) isAdmin(userName string) bool {
+	for _, mindustry := range this.list {
+		if mindustry.isAdmin(userName) {
+			return true
+		}
+	}
+	return false
+}
+
+// infos takes the lock of one instance at a time, callers must not hold any.
+// The servers of the other processes of the cluster follow the local ones.
+func (this *Servers) infos() []ServerInfo {
+	infos := []ServerInfo{}
+	for _, mindustry := range this.list {
+		infos = append(infos, mindustry.info())
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append(infos, this.remote...)
+}
+
+func (this *Mindustry) info() ServerInfo {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return ServerInfo{
+		Name:      this.id,
+		Title:     this.name,
+		Host:      this.config.cluster.host,
+		Port:      this.port,
+		Mode:      this.mode,
+		ServerRun: this.serverIsRun,
+		Players:   this.onlineCount(),
+		Map:       this.game.Map,
+	}
+}
+
+func (this *Servers) run() {
+	var wg sync.WaitGroup
+	for _, mindustry := range this.list {
+		wg.Add(1)
+		go func(mindustry *Mindustry) {
+			defer wg.Done()
+			mindustry.run()
+		}(mindustry)
+	}
+	wg.Wait()
+}
+
+// readConsole passes stdin to the servers, "@name cmd" goes to the instance
+// name and everything else to the first one.
+func (this *Servers) readConsole() {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil || io.EOF == err {
+			break
+		}
+		inputCmd := strings.TrimRight(line, "\r\n")
+		mindustry := this.get("")
+		if strings.HasPrefix(inputCmd, "@") {
+			temps := strings.SplitN(inputCmd[1:], " ", 2)
+			if mindustry = this.get(temps[0]); mindustry == nil || len(temps) < 2 {
+				commandsLog.warnf("server %s not found", temps[0])
+				continue
+			}
+			inputCmd = temps[1]
+		}
+		mindustry.lock.Lock()
+		in := mindustry.in
+		mindustry.lock.Unlock()
+		if in == nil {
+			commandsLog.warnf("server %s is not running", mindustry.id)
+			continue
+		}
+		mindustry.consoleCmd(in, "Server", inputCmd, AUDIT_CONSOLE)
+	}
+}
+
+// reloadOnSignal reloads config.ini for every instance on SIGHUP.
+func (this *Servers) reloadOnSignal(c chan os.Signal) {
+	for range c {
+		for _, mindustry := range this.list {
+			mindustry.lock.Lock()
+			mindustry.reloadConfig()
+			mindustry.lock.Unlock()
+		}
+	}
+}
+
+// route sends a request to the handler of the instance named by the server
+// query parameter, the first instance without it.
+func (this *Servers) route(newHandler func(mindustry *Mindustry) http.Handler) http.Handler {
+	handlers := make(map[string]http.Handler)
+	for _, mindustry := range this.list {
+		handlers[mindustry.id] = newHandler(mindustry)
+	}
+	first := handlers[this.list[0].id]
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("server")
+		if name == "" {
+			first.ServeHTTP(w, r)
+		} else if handler, ok := handlers[name]; ok {
+			handler.ServeHTTP(w, r)
+		} else {
+			http.Error(w, "server not found:"+name, http.StatusNotFound)
+		}
+	})
+}
+
+// prepareDir creates the saves directory of an instance and links its maps
+// to the shared ./config/maps, so the map manager serves every instance.
+func (this *Mindustry) prepareDir() {
+	if err := os.MkdirAll(this.savePath(), 0777); err != nil {
+		supervisorLog.errorf("[servers]%s:%v", this.id, err)
+	}
+	maps := filepath.Join(this.dir, "config", "maps")
+	if _, err := os.Lstat(maps); os.IsNotExist(err) {
+		shared, _ := filepath.Abs(FILE_PATH)
+		if err = os.Symlink(shared, maps); err != nil {
+			supervisorLog.warnf("[servers]%s link maps fail:%v", this.id, err)
+		}
+	}
+}
+
+func (this *Mindustry) proc_servers(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	if isOnlyCheck {
+		return true
+	}
+	//the other instances are locked one by one once this lock is released
+	go func() {
+		infos := this.servers.infos()
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if this.in == nil {
+			return
+		}
+		for _, info := range infos {
+			name, state := info.Name, "stopped"
+			if name == "" {
+				name = info.Title
+			}
+			if info.ServerRun {
+				state = info.Map
+			}
+			this.tell(this.in, userName, "info.server_info", name, info.Port, info.Mode, state, info.Players)
+		}
+	}()
+	return true
+}
+
+// proc_announce says a message on every running instance.
+func (this *Mindustry) proc_announce(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	msg := strings.TrimSpace(userInput[len("announce"):])
+	if msg == "" {
+		this.say(in, "error.cmd_announce_empty")
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	go func() {
+		for _, mindustry := range this.servers.list {
+			mindustry.lock.Lock()
+			if mindustry.in != nil {
+				mindustry.say(mindustry.in, "info.announce", userName, msg)
+			}
+			mindustry.lock.Unlock()
+		}
+	}()
+	return true
+}
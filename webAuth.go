@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SESSION_COOKIE = "mindustry_admin_session"
+const CSRF_HEADER = "X-CSRF-Token"
+const CSRF_FIELD = "csrf_token"
+const LOGIN_CODE_EXPIRE = 5 * time.Minute
+const SESSION_EXPIRE = 12 * time.Hour
+
+// a remote address may ask for LOGIN_RATE codes per LOGIN_RATE_WINDOW, at
+// most LOGIN_MAX_PENDING codes wait for \weblogin at the same time.
+const LOGIN_RATE = 5
+const LOGIN_RATE_WINDOW = time.Minute
+const LOGIN_MAX_PENDING = 100
+
+type webUserKey struct{}
+
+// webUser returns the admin a protected request was authenticated as.
+func webUser(r *http.Request) string {
+	userName, _ := r.Context().Value(webUserKey{}).(string)
+	return userName
+}
+
+// WebSession is created by a browser asking for a login code and becomes
+// valid once the admin it was requested for types \weblogin <code> in game.
+type WebSession struct {
+	id       string
+	userName string
+	csrf     string
+	code     string
+	loggedIn bool
+	expire   time.Time
+}
+
+// loginTries counts the codes a remote address asked for since reset.
+type loginTries struct {
+	count int
+	reset time.Time
+}
+
+type WebAuth struct {
+	lock     sync.Mutex
+	sessions map[string]*WebSession
+	tries    map[string]*loginTries
+	servers  *Servers
+}
+
+func newWebAuth(servers *Servers) *WebAuth {
+	return &WebAuth{sessions: make(map[string]*WebSession), tries: make(map[string]*loginTries), servers: servers}
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		httpLog.fatalf("%v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func randomCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		httpLog.fatalf("%v", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func (this *WebAuth) removeExpired() {
+	now := time.Now()
+	for id, session := range this.sessions {
+		if now.After(session.expire) {
+			delete(this.sessions, id)
+		}
+	}
+	for remote, tries := range this.tries {
+		if now.After(tries.reset) {
+			delete(this.tries, remote)
+		}
+	}
+}
+
+// allowLogin counts a code request of remote, it is called with the lock.
+func (this *WebAuth) allowLogin(remote string) bool {
+	pending := 0
+	for _, session := range this.sessions {
+		if !session.loggedIn {
+			pending++
+		}
+	}
+	if pending >= LOGIN_MAX_PENDING {
+		return false
+	}
+	tries, ok := this.tries[remote]
+	if !ok {
+		tries = &loginTries{reset: time.Now().Add(LOGIN_RATE_WINDOW)}
+		this.tries[remote] = tries
+	}
+	tries.count++
+	return tries.count <= LOGIN_RATE
+}
+
+// getSession returns a copy of the request's session.
+func (this *WebAuth) getSession(r *http.Request) (WebSession, bool) {
+	cookie, err := r.Cookie(SESSION_COOKIE)
+	if err != nil {
+		return WebSession{}, false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.removeExpired()
+	session, ok := this.sessions[cookie.Value]
+	if !ok {
+		return WebSession{}, false
+	}
+	return *session, true
+}
+
+// confirmCode is called by \weblogin, the code only logs in the browser that
+// requested it and only for the admin it was requested for.
+func (this *WebAuth) confirmCode(userName string, code string) bool {
+	if userName == "Server" {
+		return false
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.removeExpired()
+	for _, session := range this.sessions {
+		if session.loggedIn || session.userName != userName {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(session.code), []byte(code)) == 1 {
+			session.loggedIn = true
+			session.code = ""
+			session.expire = time.Now().Add(SESSION_EXPIRE)
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the logged in admin of the request. Requests that change
+// something must carry the session's csrf token in a header or form field.
+func (this *WebAuth) check(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return this.checkToken(w, strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	}
+	session, ok := this.getSession(r)
+	if !ok || !session.loggedIn {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if !this.servers.isAdmin(session.userName) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		token := r.Header.Get(CSRF_HEADER)
+		if token == "" {
+			token = r.FormValue(CSRF_FIELD)
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(session.csrf)) != 1 {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return "", false
+		}
+	}
+	return session.userName, true
+}
+
+// checkToken authenticates scripts using one of the apiTokens in config.ini,
+// they are not cookie based so no csrf token is needed.
+func (this *WebAuth) checkToken(w http.ResponseWriter, token string) (string, bool) {
+	//apiTokens is not an instance key, every instance has the same
+	mindustry := this.servers.get("")
+	mindustry.lock.Lock()
+	userName, ok := mindustry.apiTokens[hashApiToken(token)]
+	mindustry.lock.Unlock()
+	if ok && this.servers.isAdmin(userName) {
+		return userName, true
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return "", false
+}
+
+// selfAuditedRoutes write the commands they run to the audit log themselves,
+// protect leaves them out.
+var selfAuditedRoutes = []string{"/console/", API_PREFIX}
+
+// protect wraps a handler so that only logged in admins reach it, every
+// request that changes a file is written to the audit log.
+func (this *WebAuth) protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userName, ok := this.check(w, r)
+		if !ok {
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), webUserKey{}, userName))
+		if r.Method == "GET" || r.Method == "HEAD" || isSelfAudited(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		this.audit(userName, r, rec.status)
+	})
+}
+
+func isSelfAudited(urlPath string) bool {
+	for _, route := range selfAuditedRoutes {
+		if strings.HasPrefix(urlPath, route) {
+			return true
+		}
+	}
+	return false
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (this *statusRecorder) WriteHeader(status int) {
+	this.status = status
+	this.ResponseWriter.WriteHeader(status)
+}
+
+// audit records the request with the method as command and the path as args,
+// the result is "ok" or the status code of a failed request.
+func (this *WebAuth) audit(userName string, r *http.Request, status int) {
+	result := "ok"
+	if status >= 400 {
+		result = strconv.Itoa(status)
+	}
+	this.servers.auditLog.add(AuditEntry{Time: time.Now(), Actor: userName, Source: AUDIT_HTTP,
+		Command: r.Method, Args: r.URL.Path, Result: result, Remote: r.RemoteAddr})
+}
+
+type authStatus struct {
+	UserName string `json:"userName"`
+	LoggedIn bool   `json:"loggedIn"`
+	Csrf     string `json:"csrf,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	output, err := json.MarshalIndent(v, "", "\t\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(output)
+}
+
+// ServeHTTP handles /auth/status, /auth/login and /auth/logout.
+func (this *WebAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/auth/") {
+	case "status":
+		this.handleStatus(w, r)
+	case "login":
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		this.handleLogin(w, r)
+	case "logout":
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, ok := this.check(w, r); !ok {
+			return
+		}
+		if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+			this.lock.Lock()
+			delete(this.sessions, cookie.Value)
+			this.lock.Unlock()
+		}
+		http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE, Value: "", Path: "/", MaxAge: -1})
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (this *WebAuth) handleStatus(w http.ResponseWriter, r *http.Request) {
+	session, ok := this.getSession(r)
+	if !ok {
+		writeJson(w, http.StatusOK, authStatus{})
+		return
+	}
+	writeJson(w, http.StatusOK, authStatus{UserName: session.userName, LoggedIn: session.loggedIn, Csrf: session.csrf, Code: session.code})
+}
+
+// handleLogin answers every name the same way, so the page does not tell
+// who is an admin. A code requested for someone else never logs in since
+// only that admin can confirm it.
+func (this *WebAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserName string `json:"userName"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	userName := strings.TrimSpace(req.UserName)
+	if userName == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	session := &WebSession{
+		id:       randomHex(32),
+		userName: userName,
+		csrf:     randomHex(16),
+		code:     randomCode(),
+		expire:   time.Now().Add(LOGIN_CODE_EXPIRE),
+	}
+	remote := remoteHost(r)
+	this.lock.Lock()
+	this.removeExpired()
+	if !this.allowLogin(remote) {
+		this.lock.Unlock()
+		httpLog.warnf("[web]too many login codes requested from %s", r.RemoteAddr)
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	//a browser asking again replaces its pending code
+	if cookie, err := r.Cookie(SESSION_COOKIE); err == nil {
+		if old, ok := this.sessions[cookie.Value]; ok && !old.loggedIn {
+			delete(this.sessions, cookie.Value)
+		}
+	}
+	this.sessions[session.id] = session
+	this.lock.Unlock()
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE,
+		Value:    session.id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	if this.servers.isAdmin(userName) {
+		httpLog.infof("[web]login code requested for %s from %s", userName, r.RemoteAddr)
+	} else {
+		httpLog.debugf("[web]login code requested for non admin %s from %s", userName, r.RemoteAddr)
+	}
+	writeJson(w, http.StatusOK, authStatus{UserName: userName, Code: session.code})
+}
+
+// remoteHost is the address of the request without the port, which changes
+// with every connection.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// isAdmin is called by the http handlers, it takes the Mindustry lock.
+func (this *Mindustry) isAdmin(userName string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	user, ok := this.users[userName]
+	return ok && user.isAdmin
+}
+
+// adminUuids returns the uuids adminUuids allows \weblogin from for an
+// admin, none means the name is all that is checked.
+func (this *Mindustry) adminUuids(userName string) []string {
+	uuids := []string{}
+	if this.config == nil {
+		return uuids
+	}
+	for _, entry := range splitList(this.config.adminUuids) {
+		if temps := strings.Split(entry, ":"); len(temps) == 2 && temps[0] == userName {
+			uuids = append(uuids, temps[1])
+		}
+	}
+	return uuids
+}
+
+func (this *Mindustry) proc_weblogin(in io.WriteCloser, userName string, userInput string, isOnlyCheck bool) bool {
+	temps := strings.Fields(userInput)
+	if len(temps) != 2 {
+		this.say(in, "error.cmd_length_invalid", userInput)
+		return false
+	}
+	if isOnlyCheck {
+		return true
+	}
+	if uuids := this.adminUuids(userName); len(uuids) > 0 && !inList(uuids, this.online[userName].Uuid) {
+		commandsLog.with(this.id).warnf("[web]%s tried to log in with uuid %q", userName, this.online[userName].Uuid)
+		this.say(in, "error.weblogin_uuid_invalid", userName)
+		return false
+	}
+	if this.webAuth == nil || !this.webAuth.confirmCode(userName, temps[1]) {
+		this.say(in, "error.weblogin_code_invalid", userName)
+		return false
+	}
+	commandsLog.with(this.id).infof("[web]%s logged in", userName)
+	this.say(in, "info.weblogin_succ", userName)
+	return true
+}
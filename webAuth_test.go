@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleLogin(t *testing.T) {
+	auth := newWebAuth(newTestServers(t))
+	login := func(remote string, userName string) (int, string) {
+		r := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"userName":"`+userName+`"}`))
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+	adminStatus, adminBody := login("10.0.0.1:1000", "admin")
+	otherStatus, otherBody := login("10.0.0.1:1001", "nobody")
+	if adminStatus != otherStatus || adminStatus != 200 {
+		t.Fatalf("admin got %d, non admin got %d", adminStatus, otherStatus)
+	}
+	if len(adminBody) != len(otherBody)+len("admin")-len("nobody") {
+		t.Fatalf("responses differ:%s %s", adminBody, otherBody)
+	}
+	for i := 2; i < LOGIN_RATE; i++ {
+		if status, _ := login("10.0.0.1:1002", "admin"); status != 200 {
+			t.Fatalf("request %d got %d", i, status)
+		}
+	}
+	if status, _ := login("10.0.0.1:1003", "admin"); status != 429 {
+		t.Fatalf("request over the rate got %d", status)
+	}
+	if status, _ := login("10.0.0.2:1000", "admin"); status != 200 {
+		t.Fatalf("other address got %d", status)
+	}
+	if !auth.confirmCode("admin", auth.sessions[firstPending(auth, "admin")].code) {
+		t.Fatal("code not confirmed")
+	}
+	if auth.confirmCode("Server", "000000") {
+		t.Fatal("Server confirmed a code")
+	}
+
+	auth = newWebAuth(newTestServers(t))
+	for i := 0; i < LOGIN_MAX_PENDING; i++ {
+		auth.sessions[randomHex(8)] = &WebSession{userName: "admin", expire: time.Now().Add(LOGIN_CODE_EXPIRE)}
+	}
+	if status, _ := login("10.0.0.3:1000", "admin"); status != 429 {
+		t.Fatalf("login over the pending cap got %d", status)
+	}
+}
+
+func firstPending(auth *WebAuth, userName string) string {
+	for id, session := range auth.sessions {
+		if !session.loggedIn && session.userName == userName {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestWebAuthCheck(t *testing.T) {
+	servers := newTestServers(t)
+	servers.list[0].apiTokens = map[string]string{hashApiToken("secret"): "admin", hashApiToken("old"): "gone"}
+	auth := newWebAuth(servers)
+	expire := time.Now().Add(time.Hour)
+	auth.sessions["ok"] = &WebSession{id: "ok", userName: "admin", csrf: "c", loggedIn: true, expire: expire}
+	auth.sessions["pending"] = &WebSession{id: "pending", userName: "admin", csrf: "c", code: "123456", expire: expire}
+	auth.sessions["expired"] = &WebSession{id: "expired", userName: "admin", csrf: "c", loggedIn: true, expire: time.Now().Add(-time.Second)}
+	auth.sessions["demoted"] = &WebSession{id: "demoted", userName: "gone", csrf: "c", loggedIn: true, expire: expire}
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string //csrf header
+		form   string //csrf form field
+		bearer string
+		status int
+	}{
+		{"no session", "GET", "", "", "", "", 401},
+		{"unknown session", "GET", "nope", "", "", "", 401},
+		{"pending", "GET", "pending", "", "", "", 401},
+		{"expired", "GET", "expired", "", "", "", 401},
+		{"not an admin any more", "GET", "demoted", "", "", "", 401},
+		{"get", "GET", "ok", "", "", "", 200},
+		{"post without csrf", "POST", "ok", "", "", "", 403},
+		{"post with wrong csrf", "POST", "ok", "x", "", "", 403},
+		{"post with csrf header", "POST", "ok", "c", "", "", 200},
+		{"post with csrf field", "POST", "ok", "", "c", "", 200},
+		{"token", "POST", "", "", "", "secret", 200},
+		{"wrong token", "GET", "", "", "", "nope", 401},
+		{"token of a non admin", "GET", "", "", "", "old", 401},
+	}
+	handler := auth.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if webUser(r) != "admin" {
+			t.Errorf("webUser = %q", webUser(r))
+		}
+	}))
+	for _, test := range tests {
+		form := url.Values{}
+		if test.form != "" {
+			form.Set(CSRF_FIELD, test.form)
+		}
+		r := httptest.NewRequest(test.method, "/console/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: SESSION_COOKIE, Value: test.cookie})
+		}
+		if test.header != "" {
+			r.Header.Set(CSRF_HEADER, test.header)
+		}
+		if test.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+test.bearer)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+	if _, ok := auth.sessions["expired"]; ok {
+		t.Error("expired session kept")
+	}
+}
+
+func TestConfirmCode(t *testing.T) {
+	auth := newWebAuth(newTestServers(t))
+	auth.sessions["s"] = &WebSession{id: "s", userName: "admin", csrf: "c", code: "123456", expire: time.Now().Add(time.Minute)}
+	tests := []struct {
+		userName, code string
+		ok             bool
+	}{
+		{"other", "123456", false},
+		{"admin", "654321", false},
+		{"admin", "123456", true},
+		{"admin", "123456", false},
+	}
+	for i, test := range tests {
+		if ok := auth.confirmCode(test.userName, test.code); ok != test.ok {
+			t.Errorf("%d: confirmCode(%s, %s) = %v, want %v", i, test.userName, test.code, ok, test.ok)
+		}
+	}
+	session := auth.sessions["s"]
+	if !session.loggedIn || session.code != "" || time.Until(session.expire) < SESSION_EXPIRE-time.Minute {
+		t.Fatalf("session after login = %+v", session)
+	}
+}
+
+func TestWebloginUuid(t *testing.T) {
+	tests := []struct {
+		adminUuids string
+		uuid       string
+		ok         bool
+	}{
+		{"", "BBBBBBBBBBB=", true},
+		{"admin:AAAAAAAAAAA=,other:BBBBBBBBBBB=", "AAAAAAAAAAA=", true},
+		{"admin:AAAAAAAAAAA=,other:BBBBBBBBBBB=", "BBBBBBBBBBB=", false},
+		{"admin:AAAAAAAAAAA=", "", false},
+	}
+	for _, test := range tests {
+		servers := newTestServers(t)
+		m := servers.get("")
+		m.config = defaultConfig()
+		m.config.adminUuids = test.adminUuids
+		m.i18n = &Locale{messages: map[string]string{}}
+		m.online["admin"] = OnlinePlayer{Name: "admin", Uuid: test.uuid}
+		m.webAuth = newWebAuth(servers)
+		m.webAuth.sessions["s"] = &WebSession{id: "s", userName: "admin", code: "123456", expire: time.Now().Add(time.Minute)}
+		if ok := m.proc_weblogin(nopWriter{}, "admin", "weblogin 123456", false); ok != test.ok {
+			t.Errorf("adminUuids %q uuid %q: weblogin = %v, want %v", test.adminUuids, test.uuid, ok, test.ok)
+		}
+		if m.webAuth.sessions["s"].loggedIn != test.ok {
+			t.Errorf("adminUuids %q uuid %q: session logged in = %v", test.adminUuids, test.uuid, !test.ok)
+		}
+	}
+}